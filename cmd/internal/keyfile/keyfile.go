@@ -0,0 +1,266 @@
+// Package keyfile reads and writes the private key files used by wmc3 and
+// wmc3ctl, which are optionally encrypted with a passphrase.
+package keyfile
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/awnumar/memguard"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const (
+	// The PEM block type of passphrase-encrypted key files.
+	ENCRYPTED_KEY_PEM_TYPE = "COMOSUM ENCRYPTED KEY"
+
+	// The path which, when given as a key file, means the key is read from
+	// standard input instead.
+	KEY_FILE_STDIN = "-"
+
+	// Argon2id parameters used when encrypting keys. They are stored in the
+	// key file so they can be raised later without breaking existing files.
+	KEY_KDF_TIME    = 3
+	KEY_KDF_MEMORY  = 64 * 1024
+	KEY_KDF_THREADS = 4
+
+	// The most expensive parameters accepted from a key file, so a crafted
+	// file can't make loading it take all the memory or forever.
+	KEY_KDF_MAX_TIME   = 16
+	KEY_KDF_MAX_MEMORY = 4 * KEY_KDF_MEMORY
+)
+
+// Read a private key from a file into an enclave. The file either contains the
+// hex-encoded key or a passphrase-encrypted key as produced by EncryptKey, in
+// which case getPassphrase is called to obtain the passphrase. If the path is
+// KEY_FILE_STDIN, the key is read from standard input.
+//
+// Key files which can be accessed by anyone other than their owner are refused.
+func LoadKeyFile(path string, getPassphrase func() (*memguard.LockedBuffer, error)) (*memguard.Enclave, error) {
+	var r io.Reader = os.Stdin
+	if path != KEY_FILE_STDIN {
+		f, err := OpenSecretFile(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	contents, err := memguard.NewBufferFromEntireReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer contents.Destroy()
+	data := bytes.TrimSpace(contents.Bytes())
+
+	if !bytes.HasPrefix(data, []byte("-----BEGIN ")) {
+		key := make([]byte, hex.DecodedLen(len(data)))
+		defer memguard.WipeBytes(key)
+		if _, err := hex.Decode(key, data); err != nil {
+			return nil, errors.New("key is neither hex-encoded nor an encrypted key file")
+		}
+		if len(key) != ed25519.PrivateKeySize {
+			return nil, fmt.Errorf("incorrect private key size (is %d, should be %d)", len(key), ed25519.PrivateKeySize)
+		}
+		return memguard.NewEnclave(key), nil
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != ENCRYPTED_KEY_PEM_TYPE {
+		return nil, fmt.Errorf("key file is not a %s", ENCRYPTED_KEY_PEM_TYPE)
+	}
+	defer memguard.WipeBytes(block.Bytes)
+	if getPassphrase == nil {
+		return nil, errors.New("key file is encrypted but no passphrase was provided")
+	}
+	passphrase, err := getPassphrase()
+	if err != nil {
+		return nil, errors.Join(errors.New("failed to get passphrase"), err)
+	}
+	defer passphrase.Destroy()
+
+	return decryptKey(block, passphrase.Bytes())
+}
+
+// Encrypt a private key with a passphrase, returning the contents of a key file
+// which can be read by LoadKeyFile.
+func EncryptKey(key ed25519.PrivateKey, passphrase []byte) ([]byte, error) {
+	if len(key) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("incorrect private key size (is %d, should be %d)", len(key), ed25519.PrivateKeySize)
+	}
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	block := &pem.Block{
+		Type: ENCRYPTED_KEY_PEM_TYPE,
+		Headers: map[string]string{
+			"KDF":     "argon2id",
+			"Salt":    hex.EncodeToString(salt),
+			"Time":    strconv.Itoa(KEY_KDF_TIME),
+			"Memory":  strconv.Itoa(KEY_KDF_MEMORY),
+			"Threads": strconv.Itoa(KEY_KDF_THREADS),
+		},
+	}
+
+	aead, err := keyFileCipher(block, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(key)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	block.Bytes = aead.Seal(nonce, nonce, key, nil)
+
+	return pem.EncodeToMemory(block), nil
+}
+
+// Open a file holding secrets, refusing to do so if anyone other than its
+// owner can access it.
+func OpenSecretFile(path string) (*os.File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if perm := info.Mode().Perm(); perm&0o077 != 0 {
+		f.Close()
+		return nil, fmt.Errorf("%s is accessible by other users (mode %#o), it should not be readable by anyone but its owner", path, perm)
+	}
+
+	return f, nil
+}
+
+// Read the whole of a file that only its owner may access, as checked by
+// OpenSecretFile.
+func ReadSecretFile(path string) ([]byte, error) {
+	f, err := OpenSecretFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return io.ReadAll(f)
+}
+
+// Build a function which reads a key file passphrase from the first line of the
+// file at the given path, or from stdin if the path is KEY_FILE_STDIN. The
+// passphrase is only read once, on first use, and kept in an enclave after that
+// so it can be used for several key files. Returns nil if the path is empty.
+func PassphraseFile(path string) func() (*memguard.LockedBuffer, error) {
+	if path == "" {
+		return nil
+	}
+
+	var (
+		once    sync.Once
+		enclave *memguard.Enclave
+		err     error
+	)
+	return func() (*memguard.LockedBuffer, error) {
+		once.Do(func() {
+			var r io.Reader = os.Stdin
+			if path != KEY_FILE_STDIN {
+				f, ferr := OpenSecretFile(path)
+				if ferr != nil {
+					err = ferr
+					return
+				}
+				defer f.Close()
+				r = f
+			}
+
+			// Only the first line is used, so a passphrase can be typed in.
+			contents, rerr := memguard.NewBufferFromReaderUntil(r, '\n')
+			if rerr != nil && !errors.Is(rerr, io.EOF) {
+				err = rerr
+				return
+			}
+			defer contents.Destroy()
+			contents.Melt()
+			passphrase := bytes.TrimRight(contents.Bytes(), "\r")
+			if len(passphrase) == 0 {
+				err = errors.New("passphrase is empty")
+				return
+			}
+			enclave = memguard.NewEnclave(passphrase)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return enclave.Open()
+	}
+}
+
+func decryptKey(block *pem.Block, passphrase []byte) (*memguard.Enclave, error) {
+	aead, err := keyFileCipher(block, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if len(block.Bytes) < aead.NonceSize() {
+		return nil, errors.New("key file is truncated")
+	}
+	nonce, ciphertext := block.Bytes[:aead.NonceSize()], block.Bytes[aead.NonceSize():]
+
+	key, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, errors.New("wrong passphrase or corrupted key file")
+	}
+	if len(key) != ed25519.PrivateKeySize {
+		memguard.WipeBytes(key)
+		return nil, fmt.Errorf("incorrect private key size (is %d, should be %d)", len(key), ed25519.PrivateKeySize)
+	}
+
+	return memguard.NewEnclave(key), nil
+}
+
+// Derive the key file encryption key from the passphrase and the KDF
+// parameters in the block headers.
+func keyFileCipher(block *pem.Block, passphrase []byte) (cipher.AEAD, error) {
+	if kdf := block.Headers["KDF"]; kdf != "argon2id" {
+		return nil, fmt.Errorf("unsupported key derivation function %q", kdf)
+	}
+	salt, err := hex.DecodeString(block.Headers["Salt"])
+	if err != nil {
+		return nil, errors.Join(errors.New("invalid salt in key file"), err)
+	}
+	params := map[string]uint64{}
+	for _, name := range []string{"Time", "Memory", "Threads"} {
+		params[name], err = strconv.ParseUint(block.Headers[name], 10, 32)
+		if err != nil || params[name] == 0 {
+			return nil, fmt.Errorf("invalid %s parameter in key file", name)
+		}
+	}
+	if params["Threads"] > 255 {
+		return nil, errors.New("invalid Threads parameter in key file")
+	}
+	if params["Time"] > KEY_KDF_MAX_TIME {
+		return nil, fmt.Errorf("too high Time parameter in key file (maximum %d)", KEY_KDF_MAX_TIME)
+	}
+	if params["Memory"] > KEY_KDF_MAX_MEMORY {
+		return nil, fmt.Errorf("too high Memory parameter in key file (maximum %d)", KEY_KDF_MAX_MEMORY)
+	}
+
+	secret := argon2.IDKey(passphrase, salt, uint32(params["Time"]), uint32(params["Memory"]), uint8(params["Threads"]), chacha20poly1305.KeySize)
+	defer memguard.WipeBytes(secret)
+
+	return chacha20poly1305.NewX(secret)
+}
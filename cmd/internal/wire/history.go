@@ -0,0 +1,35 @@
+package wire
+
+import (
+	"time"
+
+	"dev.l1qu1d.net/wraith-labs/wraith_module_comosum/radio"
+)
+
+// The NATS subject on which the C2 answers HistoryQuery requests.
+const HISTORY_QUERY_SUBJECT = "comosum.history.query"
+
+// A request for past events. All fields are optional and narrow down the
+// results when set.
+type HistoryQuery struct {
+	Client string    `json:"client,omitempty"`
+	Op     string    `json:"op,omitempty"`
+	Key    string    `json:"key,omitempty"`
+	Since  time.Time `json:"since,omitempty"`
+	Until  time.Time `json:"until,omitempty"`
+
+	// The maximum number of events to return. The C2 applies its own limit if
+	// this is zero or too large.
+	Limit int `json:"limit,omitempty"`
+}
+
+// The response to a HistoryQuery.
+type HistoryResult struct {
+	Events []radio.Event `json:"events"`
+
+	// Whether there were more matching events than returned.
+	Truncated bool `json:"truncated,omitempty"`
+
+	// Set if the query could not be completed.
+	Error string `json:"error,omitempty"`
+}
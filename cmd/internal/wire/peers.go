@@ -0,0 +1,53 @@
+// Package wire defines the requests and responses exchanged between wmc3 and
+// the tools managing it over NATS.
+package wire
+
+import "dev.l1qu1d.net/wraith-labs/wraith_module_comosum/radio"
+
+// The NATS subject on which the C2 answers PeersRequest messages. Any one
+// instance answers requests sent to it directly; a specific instance can be
+// addressed on PEERS_SUBJECT.<instance name>.
+const PEERS_SUBJECT = "comosum.peers"
+
+// Peer management operations.
+const (
+	PEERS_OP_LIST   = "list"
+	PEERS_OP_ADD    = "add"
+	PEERS_OP_REMOVE = "remove"
+	PEERS_OP_SET    = "set"
+	PEERS_OP_FILTER = "filter"
+)
+
+// A request to inspect or change the Yggdrasil peers of a C2 instance.
+type PeersRequest struct {
+	// One of the PEERS_OP_* constants. Defaults to PEERS_OP_LIST.
+	Op string `json:"op,omitempty"`
+
+	// The peer URIs to add or remove, or the full set of peers to use.
+	URIs []string `json:"uris,omitempty"`
+
+	// Must be set to use PEERS_OP_SET with no URIs, which drops all
+	// configured peers, so that can't happen by accident.
+	Clear bool `json:"clear,omitempty"`
+
+	// The peer filter to use from now on, for PEERS_OP_FILTER.
+	Filter *radio.PeerFilter `json:"filter,omitempty"`
+}
+
+// The response to a PeersRequest, describing the peers after the operation.
+type PeersResult struct {
+	// The name of the instance that answered.
+	Instance string `json:"instance"`
+
+	// The persistent outbound peers the instance is configured with.
+	Configured []string `json:"configured"`
+
+	// All current peerings, configured or not.
+	Peers []radio.PeerStatus `json:"peers"`
+
+	// Which nodes may peer with the instance inbound.
+	Filter radio.PeerFilter `json:"filter"`
+
+	// Set if the operation failed, in part or entirely.
+	Error string `json:"error,omitempty"`
+}
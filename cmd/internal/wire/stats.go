@@ -0,0 +1,16 @@
+package wire
+
+import "dev.l1qu1d.net/wraith-labs/wraith_module_comosum/radio"
+
+// The NATS subject on which the C2 answers requests for node statistics with
+// a StatsResult. Any one instance answers requests sent to it directly; a
+// specific instance can be addressed on STATS_SUBJECT.<instance name>.
+const STATS_SUBJECT = "comosum.stats"
+
+// The response to a request on STATS_SUBJECT.
+type StatsResult struct {
+	// The name of the instance that answered.
+	Instance string `json:"instance"`
+
+	Stats radio.NodeStats `json:"stats"`
+}
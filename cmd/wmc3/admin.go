@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+// A local HTTP listener for operational endpoints such as metrics. This is
+// meant for the machine running wmc3 and should not be exposed publicly.
+type adminServer struct {
+	server   *http.Server
+	listener net.Listener
+	logger   *slog.Logger
+}
+
+func newAdminServer(listen string, handler http.Handler, logger *slog.Logger) (*adminServer, error) {
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		return nil, err
+	}
+
+	return &adminServer{
+		server: &http.Server{
+			Handler:           handler,
+			ReadHeaderTimeout: 5 * time.Second,
+		},
+		listener: listener,
+		logger:   logger,
+	}, nil
+}
+
+func (a *adminServer) Serve() {
+	if err := a.server.Serve(a.listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		a.logger.Error("admin listener stopped", "err", err)
+	}
+}
+
+func (a *adminServer) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	return a.server.Shutdown(ctx)
+}
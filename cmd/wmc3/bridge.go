@@ -0,0 +1,323 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+)
+
+// How long one direction of a bridged connection may keep going once the
+// other has ended, so a peer can't hold a connection open by going quiet.
+const BRIDGE_LINGER = 10 * time.Second
+
+// Accounting information about a single remote peer of the bridge, or about
+// all of them together.
+type bridgePeerStats struct {
+	// Number of currently open connections.
+	Active int
+
+	// Number of connections accepted.
+	Total uint64
+
+	// Number of connections turned away due to limits.
+	Rejected uint64
+
+	// Bytes received from and sent to the peer so far.
+	BytesIn  uint64
+	BytesOut uint64
+}
+
+// Forwards connections accepted on a listener (usually on the Yggdrasil
+// netstack) to connections created by a dialer (usually in-process NATS
+// connections).
+type bridge struct {
+	listener net.Listener
+	dial     func() (net.Conn, error)
+	logger   *slog.Logger
+
+	// Maximum number of concurrent connections in total and per remote peer.
+	// Zero means unlimited.
+	maxConns        int
+	maxConnsPerPeer int
+
+	// See BRIDGE_LINGER.
+	linger time.Duration
+
+	mu      sync.Mutex
+	closing bool
+	conns   map[net.Conn]struct{}
+	// Peers with open connections. A peer is forgotten once its last
+	// connection closes, but still counts towards totals.
+	peers  map[string]*bridgePeerStats
+	totals bridgePeerStats
+
+	wg sync.WaitGroup
+}
+
+func newBridge(listener net.Listener, dial func() (net.Conn, error), logger *slog.Logger, maxConns, maxConnsPerPeer int) *bridge {
+	return &bridge{
+		listener:        listener,
+		dial:            dial,
+		logger:          logger,
+		maxConns:        maxConns,
+		maxConnsPerPeer: maxConnsPerPeer,
+		linger:          BRIDGE_LINGER,
+		conns:           map[net.Conn]struct{}{},
+		peers:           map[string]*bridgePeerStats{},
+	}
+}
+
+// Accept and forward connections until the bridge is closed. Returns nil if
+// the bridge was closed with Close, or the error that stopped the accept loop
+// otherwise.
+func (b *bridge) Serve() error {
+	var backoff time.Duration
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			if b.isClosing() || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				// Back off on transient errors so we don't spin.
+				if backoff == 0 {
+					backoff = 5 * time.Millisecond
+				} else if backoff < time.Second {
+					backoff *= 2
+				}
+				b.logger.Warn("bridge accept error; retrying", "err", err, "backoff", backoff)
+				time.Sleep(backoff)
+				continue
+			}
+			return err
+		}
+		backoff = 0
+
+		peer := peerHost(conn.RemoteAddr())
+		if !b.admit(peer) {
+			b.logger.Debug("bridge rejected connection: connection limit reached", "peer", peer)
+			conn.Close()
+			continue
+		}
+
+		upstream, err := b.dial()
+		if err != nil {
+			b.logger.Error("bridge failed to open upstream connection", "peer", peer, "err", err)
+			b.release(peer)
+			conn.Close()
+			continue
+		}
+
+		if !b.track(conn, upstream) {
+			b.release(peer)
+			conn.Close()
+			upstream.Close()
+			continue
+		}
+		go func() {
+			defer b.wg.Done()
+			b.forward(peer, conn, upstream)
+		}()
+	}
+}
+
+// Stop accepting new connections, close all forwarded connections and wait
+// for their goroutines to exit.
+func (b *bridge) Close() error {
+	b.mu.Lock()
+	b.closing = true
+	err := b.listener.Close()
+	for conn := range b.conns {
+		conn.Close()
+	}
+	b.mu.Unlock()
+
+	b.wg.Wait()
+
+	return err
+}
+
+// Number of currently forwarded connections.
+func (b *bridge) Active() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.totals.Active
+}
+
+// A snapshot of accounting information for all peers together, since the
+// bridge was created.
+func (b *bridge) Totals() bridgePeerStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.totals
+}
+
+// A snapshot of accounting information for the peers with open connections.
+func (b *bridge) Stats() map[string]bridgePeerStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	stats := make(map[string]bridgePeerStats, len(b.peers))
+	for peer, s := range b.peers {
+		stats[peer] = *s
+	}
+
+	return stats
+}
+
+func (b *bridge) isClosing() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.closing
+}
+
+// Check connection limits for the given peer and reserve a slot if allowed.
+func (b *bridge) admit(peer string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	stats := b.peers[peer]
+	active := 0
+	if stats != nil {
+		active = stats.Active
+	}
+
+	if b.closing ||
+		(b.maxConns > 0 && b.totals.Active >= b.maxConns) ||
+		(b.maxConnsPerPeer > 0 && active >= b.maxConnsPerPeer) {
+		b.totals.Rejected++
+		if stats != nil {
+			stats.Rejected++
+		}
+		return false
+	}
+
+	if stats == nil {
+		stats = &bridgePeerStats{}
+		b.peers[peer] = stats
+	}
+	b.totals.Active++
+	b.totals.Total++
+	stats.Active++
+	stats.Total++
+
+	return true
+}
+
+// Register the given connections so Close can tear them down and account for
+// the goroutine forwarding them. Returns false if the bridge is already
+// closing, in which case the caller is responsible for cleaning up.
+func (b *bridge) track(conns ...net.Conn) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closing {
+		return false
+	}
+	for _, conn := range conns {
+		b.conns[conn] = struct{}{}
+	}
+	b.wg.Add(1)
+
+	return true
+}
+
+// Give back the slot reserved by admit and forget about the given connections.
+func (b *bridge) release(peer string, conns ...net.Conn) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.totals.Active--
+	stats := b.peers[peer]
+	stats.Active--
+	if stats.Active == 0 {
+		delete(b.peers, peer)
+	}
+	for _, conn := range conns {
+		delete(b.conns, conn)
+	}
+}
+
+// Count bytes received from (in) or sent to (out) the given peer.
+func (b *bridge) count(peer string, in, out int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	stats := b.peers[peer]
+	stats.BytesIn += uint64(in)
+	stats.BytesOut += uint64(out)
+	b.totals.BytesIn += uint64(in)
+	b.totals.BytesOut += uint64(out)
+}
+
+// Copy data in both directions until both sides are done, then clean up. Once
+// one direction ends, the other gets at most the linger time to finish.
+func (b *bridge) forward(peer string, downstream, upstream net.Conn) {
+	defer b.release(peer, downstream, upstream)
+	defer upstream.Close()
+	defer downstream.Close()
+
+	done := make(chan struct{}, 2)
+	go func() {
+		pipe(upstream, downstream, func(n int) { b.count(peer, n, 0) })
+		done <- struct{}{}
+	}()
+	go func() {
+		pipe(downstream, upstream, func(n int) { b.count(peer, 0, n) })
+		done <- struct{}{}
+	}()
+
+	<-done
+	deadline := time.Now().Add(b.linger)
+	_ = downstream.SetDeadline(deadline)
+	_ = upstream.SetDeadline(deadline)
+	<-done
+}
+
+// Reports the bytes read through it as they come in.
+type countingReader struct {
+	r     io.Reader
+	count func(int)
+}
+
+func (c countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if n > 0 {
+		c.count(n)
+	}
+	return n, err
+}
+
+// Copy from src to dst, then propagate the end of the stream to dst. If dst
+// supports half-closing, only its write side is closed so that data still
+// flowing in the other direction is not cut off.
+func pipe(dst, src net.Conn, count func(int)) {
+	_, _ = io.Copy(dst, countingReader{src, count})
+
+	if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+		if cw.CloseWrite() == nil {
+			return
+		}
+	}
+	dst.Close()
+}
+
+// Extract the host part of an address to identify peers by.
+func peerHost(addr net.Addr) string {
+	if addr == nil {
+		return "<unknown>"
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+
+	return host
+}
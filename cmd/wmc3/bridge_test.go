@@ -0,0 +1,224 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"dev.l1qu1d.net/wraith-labs/wraith_module_comosum/radio"
+)
+
+// A listener handing out one end of in-memory pipes, the other end of which
+// is returned by dial.
+type pipeListener struct {
+	conns  chan net.Conn
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newPipeListener() *pipeListener {
+	return &pipeListener{conns: make(chan net.Conn), closed: make(chan struct{})}
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *pipeListener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr { return pipeAddr("listener") }
+
+// Connect to the listener as the given peer.
+func (l *pipeListener) dial(t *testing.T, peer string) net.Conn {
+	t.Helper()
+
+	local, remote := net.Pipe()
+	select {
+	case l.conns <- addrConn{remote, pipeAddr(peer + ":1234")}:
+	case <-time.After(time.Second):
+		t.Fatal("bridge did not accept connection")
+	}
+	t.Cleanup(func() { local.Close() })
+	return local
+}
+
+type pipeAddr string
+
+func (a pipeAddr) Network() string { return "pipe" }
+func (a pipeAddr) String() string  { return string(a) }
+
+// A connection with a chosen remote address.
+type addrConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c addrConn) RemoteAddr() net.Addr { return c.remote }
+
+// Dial in-memory upstream connections served by an echo server.
+func echoDialer() (net.Conn, error) {
+	local, remote := net.Pipe()
+	go func() {
+		defer remote.Close()
+		_, _ = io.Copy(remote, remote)
+	}()
+	return local, nil
+}
+
+// Serve the bridge until the test is over.
+func startBridge(t *testing.T, b *bridge) *bridge {
+	t.Helper()
+
+	served := make(chan error, 1)
+	go func() { served <- b.Serve() }()
+	t.Cleanup(func() {
+		if err := b.Close(); err != nil {
+			t.Errorf("closing bridge: %v", err)
+		}
+		if err := <-served; err != nil {
+			t.Errorf("serving bridge: %v", err)
+		}
+	})
+	return b
+}
+
+// Check that data sent on conn comes back, meaning the bridge forwards it.
+func assertEcho(t *testing.T, conn net.Conn, data string) {
+	t.Helper()
+
+	_ = conn.SetDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Write([]byte(data)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	buf := make([]byte, len(data))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(buf) != data {
+		t.Fatalf("got %q back, want %q", buf, data)
+	}
+}
+
+// Check that the bridge closed conn without forwarding it.
+func assertRejected(t *testing.T, conn net.Conn) {
+	t.Helper()
+
+	_ = conn.SetDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrClosedPipe) {
+		t.Fatalf("connection was not closed by the bridge: %v", err)
+	}
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	for deadline := time.Now().Add(2 * time.Second); !cond(); time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+func TestBridgePerPeerLimit(t *testing.T) {
+	l := newPipeListener()
+	b := startBridge(t, newBridge(l, echoDialer, radio.DiscardLogger(), 0, 1))
+
+	first := l.dial(t, "a")
+	assertEcho(t, first, "hello")
+	assertRejected(t, l.dial(t, "a"))
+	assertEcho(t, l.dial(t, "b"), "hello")
+
+	stats := b.Stats()
+	if stats["a"].Active != 1 || stats["a"].Rejected != 1 {
+		t.Errorf("peer a has %+v, want 1 active and 1 rejected", stats["a"])
+	}
+	if b.Active() != 2 {
+		t.Errorf("bridge has %d active connections, want 2", b.Active())
+	}
+
+	// The slot is free again once the first connection closes.
+	first.Close()
+	waitFor(t, "peer a to disconnect", func() bool { return b.Stats()["a"].Active == 0 })
+	assertEcho(t, l.dial(t, "a"), "hello")
+}
+
+func TestBridgeTotalLimit(t *testing.T) {
+	l := newPipeListener()
+	b := startBridge(t, newBridge(l, echoDialer, radio.DiscardLogger(), 1, 0))
+
+	first := l.dial(t, "a")
+	assertEcho(t, first, "hello")
+	assertRejected(t, l.dial(t, "b"))
+
+	if totals := b.Totals(); totals.Total != 1 || totals.Rejected != 1 {
+		t.Errorf("totals are %+v, want 1 accepted and 1 rejected", totals)
+	}
+	if _, ok := b.Stats()["b"]; ok {
+		t.Error("rejected peer b is tracked although it has no connections")
+	}
+
+	first.Close()
+	waitFor(t, "all connections to close", func() bool { return b.Active() == 0 })
+	if len(b.Stats()) != 0 {
+		t.Errorf("peers without connections are still tracked: %v", b.Stats())
+	}
+	assertEcho(t, l.dial(t, "b"), "hello")
+}
+
+func TestBridgeCountsBytes(t *testing.T) {
+	l := newPipeListener()
+	b := startBridge(t, newBridge(l, echoDialer, radio.DiscardLogger(), 0, 0))
+
+	conn := l.dial(t, "a")
+	assertEcho(t, conn, "hello")
+	assertEcho(t, conn, "world!")
+
+	// Bytes are counted while the connection is still open.
+	if stats := b.Stats()["a"]; stats.BytesIn != 11 || stats.BytesOut != 11 {
+		t.Errorf("peer a has %+v, want 11 bytes in and out", stats)
+	}
+
+	conn.Close()
+	waitFor(t, "the connection to close", func() bool { return b.Active() == 0 })
+	if totals := b.Totals(); totals.BytesIn != 11 || totals.BytesOut != 11 {
+		t.Errorf("totals are %+v, want 11 bytes in and out", totals)
+	}
+}
+
+func TestBridgeLinger(t *testing.T) {
+	// Use TCP, which supports half-closing, so the idle side stays open.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The upstream side hangs up straight away.
+	dial := func() (net.Conn, error) {
+		local, remote := net.Pipe()
+		remote.Close()
+		return local, nil
+	}
+	b := newBridge(l, dial, radio.DiscardLogger(), 0, 0)
+	b.linger = 50 * time.Millisecond
+	startBridge(t, b)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// The peer never sends or closes, but the bridge lets go anyway.
+	waitFor(t, "the bridge to accept", func() bool { return b.Totals().Total == 1 })
+	waitFor(t, "the idle connection to be dropped", func() bool { return b.Active() == 0 })
+}
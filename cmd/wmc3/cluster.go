@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/nats-io/nats-server/v2/server"
+)
+
+// Configure the NATS server to form a cluster with other wmc3 instances and/or
+// to accept and make leafnode connections, as configured. Routes and leafnodes
+// use the client TLS config unless a CA for them is configured.
+func applyCluster(opts *server.Options, c conf, tlsConfig *tls.Config) error {
+	opts.ServerName = c.NatsServerName
+	opts.JetStreamDomain = c.NatsJetStreamDomain
+
+	if c.NatsClusterListener != "" {
+		host, port, err := parseHostPort(c.NatsClusterListener)
+		if err != nil {
+			return errors.Join(errors.New("failed to parse NATS cluster listen string"), err)
+		}
+
+		opts.Cluster.Name = c.NatsClusterName
+		opts.Cluster.Host = host
+		opts.Cluster.Port = port
+		opts.Cluster.Username = c.NatsClusterUser
+		opts.Cluster.Password = c.NatsClusterPass
+		opts.Cluster.TLSConfig = tlsConfig
+		if c.NatsClusterTLSCA != "" {
+			opts.Cluster.TLSConfig, err = makePeerTLSConfig(c, c.NatsClusterTLSCA)
+			if err != nil {
+				return errors.Join(errors.New("failed to set up NATS cluster TLS"), err)
+			}
+		}
+		opts.Routes = c.NatsClusterRoutes
+	}
+
+	if c.NatsLeafnodeListener != "" {
+		host, port, err := parseHostPort(c.NatsLeafnodeListener)
+		if err != nil {
+			return errors.Join(errors.New("failed to parse NATS leafnode listen string"), err)
+		}
+
+		// Leafnodes authenticate with the same users as clients and end up in
+		// the account of the user they authenticate as.
+		opts.LeafNode.Host = host
+		opts.LeafNode.Port = port
+		opts.LeafNode.TLSConfig = tlsConfig
+	}
+
+	var leafTLSConfig *tls.Config
+	if c.NatsLeafnodeTLSCA != "" {
+		var err error
+		leafTLSConfig, err = makePeerTLSConfig(c, c.NatsLeafnodeTLSCA)
+		if err != nil {
+			return errors.Join(errors.New("failed to set up NATS leafnode TLS"), err)
+		}
+		if c.NatsLeafnodeListener != "" {
+			opts.LeafNode.TLSConfig = leafTLSConfig
+		}
+	}
+
+	for _, remote := range c.NatsLeafnodeRemotes {
+		opts.LeafNode.Remotes = append(opts.LeafNode.Remotes, &server.RemoteLeafOpts{
+			LocalAccount: NATS_APP_ACCOUNT,
+			URLs:         []*url.URL{remote},
+			TLS:          remote.Scheme == "tls" || leafTLSConfig != nil,
+			TLSConfig:    leafTLSConfig,
+		})
+	}
+
+	return nil
+}
+
+// Split a host:port string and parse the port.
+func parseHostPort(hostport string) (string, int, error) {
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return "", 0, err
+	}
+	portNum, err := strconv.Atoi(port)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return host, portNum, nil
+}
+
+// Parse a comma-separated list of NATS URLs.
+func parseNatsURLs(list string) ([]*url.URL, error) {
+	urls := []*url.URL{}
+	if list == "" {
+		return urls, nil
+	}
+	for _, raw := range strings.Split(list, ",") {
+		u, err := url.Parse(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, err
+		}
+		if u.Host == "" {
+			return nil, errors.New("URL is missing a host")
+		}
+		urls = append(urls, u)
+	}
+
+	return urls, nil
+}
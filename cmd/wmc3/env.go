@@ -5,36 +5,134 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"net"
 	"net/url"
 	"os"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
+
+	"dev.l1qu1d.net/wraith-labs/wraith_module_comosum/cmd/internal/keyfile"
+	"dev.l1qu1d.net/wraith-labs/wraith_module_comosum/radio"
+	"github.com/awnumar/memguard"
 )
 
 const (
 	ENVIRONMENT_PREFIX = "WMC3_"
 
-	ENV_DEBUG = ENVIRONMENT_PREFIX + "DEBUG"
+	ENV_DEBUG            = ENVIRONMENT_PREFIX + "DEBUG"
+	ENV_SHUTDOWN_TIMEOUT = ENVIRONMENT_PREFIX + "SHUTDOWN_TIMEOUT"
+	ENV_ADMIN_LISTENER   = ENVIRONMENT_PREFIX + "ADMIN_LISTENER"
+
+	ENV_ADMIN_KEY_FILE      = ENVIRONMENT_PREFIX + "ADMIN_KEY_FILE"
+	ENV_ADMIN_PUBLIC_KEY    = ENVIRONMENT_PREFIX + "ADMIN_PUBLIC_KEY"
+	ENV_KEY_PASSPHRASE_FILE = ENVIRONMENT_PREFIX + "KEY_PASSPHRASE_FILE"
+
+	ENV_YGG_IDENTITY      = ENVIRONMENT_PREFIX + "YGG_IDENTITY"
+	ENV_YGG_IDENTITY_FILE = ENVIRONMENT_PREFIX + "YGG_IDENTITY_FILE"
+	ENV_YGG_AUTHORIZATION = ENVIRONMENT_PREFIX + "YGG_AUTHORIZATION"
+	ENV_YGG_STATIC_PEERS  = ENVIRONMENT_PREFIX + "YGG_STATIC_PEERS"
+	ENV_YGG_LISTENERS     = ENVIRONMENT_PREFIX + "YGG_LISTENERS"
+	ENV_YGG_READY_PEERS   = ENVIRONMENT_PREFIX + "YGG_READY_PEERS"
+	ENV_YGG_ALLOWED_PEERS = ENVIRONMENT_PREFIX + "YGG_ALLOWED_PEERS"
+	ENV_YGG_DENIED_PEERS  = ENVIRONMENT_PREFIX + "YGG_DENIED_PEERS"
+	ENV_YGG_CONFIG_FILE   = ENVIRONMENT_PREFIX + "YGG_CONFIG_FILE"
 
-	ENV_YGG_IDENTITY     = ENVIRONMENT_PREFIX + "YGG_IDENTITY"
-	ENV_YGG_STATIC_PEERS = ENVIRONMENT_PREFIX + "YGG_STATIC_PEERS"
-	ENV_YGG_LISTENERS    = ENVIRONMENT_PREFIX + "YGG_LISTENERS"
+	ENV_BRIDGE_MAX_CONNS          = ENVIRONMENT_PREFIX + "BRIDGE_MAX_CONNS"
+	ENV_BRIDGE_MAX_CONNS_PER_PEER = ENVIRONMENT_PREFIX + "BRIDGE_MAX_CONNS_PER_PEER"
 
 	ENV_NATS_ADMIN_USER = ENVIRONMENT_PREFIX + "NATS_ADMIN_USER"
 	ENV_NATS_ADMIN_PASS = ENVIRONMENT_PREFIX + "NATS_ADMIN_PASS"
 	ENV_NATS_LISTENER   = ENVIRONMENT_PREFIX + "NATS_LISTENER"
+	ENV_NATS_OPERATORS  = ENVIRONMENT_PREFIX + "NATS_OPERATORS"
+	ENV_NATS_STORE_DIR  = ENVIRONMENT_PREFIX + "NATS_STORE_DIR"
+
+	ENV_NATS_SERVER_NAME       = ENVIRONMENT_PREFIX + "NATS_SERVER_NAME"
+	ENV_NATS_JETSTREAM_DOMAIN  = ENVIRONMENT_PREFIX + "NATS_JETSTREAM_DOMAIN"
+	ENV_NATS_CLUSTER_NAME      = ENVIRONMENT_PREFIX + "NATS_CLUSTER_NAME"
+	ENV_NATS_CLUSTER_LISTENER  = ENVIRONMENT_PREFIX + "NATS_CLUSTER_LISTENER"
+	ENV_NATS_CLUSTER_ROUTES    = ENVIRONMENT_PREFIX + "NATS_CLUSTER_ROUTES"
+	ENV_NATS_CLUSTER_USER      = ENVIRONMENT_PREFIX + "NATS_CLUSTER_USER"
+	ENV_NATS_CLUSTER_PASS      = ENVIRONMENT_PREFIX + "NATS_CLUSTER_PASS"
+	ENV_NATS_CLUSTER_TLS_CA    = ENVIRONMENT_PREFIX + "NATS_CLUSTER_TLS_CA"
+	ENV_NATS_LEAFNODE_LISTENER = ENVIRONMENT_PREFIX + "NATS_LEAFNODE_LISTENER"
+	ENV_NATS_LEAFNODE_REMOTES  = ENVIRONMENT_PREFIX + "NATS_LEAFNODE_REMOTES"
+	ENV_NATS_LEAFNODE_TLS_CA   = ENVIRONMENT_PREFIX + "NATS_LEAFNODE_TLS_CA"
+
+	ENV_NATS_TLS_CERT        = ENVIRONMENT_PREFIX + "NATS_TLS_CERT"
+	ENV_NATS_TLS_KEY         = ENVIRONMENT_PREFIX + "NATS_TLS_KEY"
+	ENV_NATS_TLS_CLIENT_CA   = ENVIRONMENT_PREFIX + "NATS_TLS_CLIENT_CA"
+	ENV_NATS_TLS_SELF_SIGNED = ENVIRONMENT_PREFIX + "NATS_TLS_SELF_SIGNED"
+	ENV_NATS_WS_LISTENER     = ENVIRONMENT_PREFIX + "NATS_WS_LISTENER"
+
+	ENV_EVENTS_MAX_AGE   = ENVIRONMENT_PREFIX + "EVENTS_MAX_AGE"
+	ENV_EVENTS_MAX_BYTES = ENVIRONMENT_PREFIX + "EVENTS_MAX_BYTES"
+	ENV_EVENTS_REPLICAS  = ENVIRONMENT_PREFIX + "EVENTS_REPLICAS"
+	ENV_WEBHOOKS         = ENVIRONMENT_PREFIX + "WEBHOOKS"
 )
 
 type conf struct {
-	Debug bool
+	Debug           bool
+	ShutdownTimeout time.Duration
+	AdminListener   string
+
+	// The admin signing key, or nil if none was given.
+	AdminKey *memguard.Enclave
+	// The admin public key, or nil if neither it nor the admin key was given.
+	AdminPubKey ed25519.PublicKey
+
+	YggIdentity  *memguard.Enclave
+	YggPublicKey ed25519.PublicKey
+	// The admin key's signature allowing this node to act as the C2, or nil
+	// if none was given.
+	YggAuthorization []byte
 
-	YggIdentity    ed25519.PrivateKey
 	YggStaticPeers []string
 	YggListeners   []string
+	YggReadyPeers  int
+	// Public keys of the nodes that may or may not peer with this one inbound.
+	YggAllowedPeers []string
+	YggDeniedPeers  []string
+	// Everything else from the Yggdrasil configuration file, if one was given.
+	// Its private key is always dropped in favour of YggIdentity.
+	YggConfig radio.NodeOptions
+
+	BridgeMaxConns        int
+	BridgeMaxConnsPerPeer int
 
 	NatsAdminUser string
 	NatsAdminPass string
 	NatsListener  string
+	NatsOperators []operator
+	NatsStoreDir  string
+
+	NatsServerName       string
+	NatsJetStreamDomain  string
+	NatsClusterName      string
+	NatsClusterListener  string
+	NatsClusterRoutes    []*url.URL
+	NatsClusterUser      string
+	NatsClusterPass      string
+	NatsLeafnodeListener string
+	NatsLeafnodeRemotes  []*url.URL
+	// CAs which other servers' route and leafnode certificates must be signed
+	// by. If unset, those connections use the client TLS settings, under which
+	// other servers must present certificates trusted by the system roots.
+	NatsClusterTLSCA  string
+	NatsLeafnodeTLSCA string
+
+	NatsTLSCert           string
+	NatsTLSKey            string
+	NatsTLSClientCA       string
+	NatsTLSSelfSigned     bool
+	NatsWebsocketListener string
+
+	EventsMaxAge   time.Duration
+	EventsMaxBytes int64
+	EventsReplicas int
+	Webhooks       []webhookConfig
 }
 
 func MakeConf() conf {
@@ -47,19 +145,138 @@ func MakeConf() conf {
 		panic(errors.Join(errors.New(fmt.Sprintf("could not parse value of env var %s", ENV_DEBUG)), err))
 	}
 
+	envShutdownTimeout := os.Getenv(ENV_SHUTDOWN_TIMEOUT)
+	if envShutdownTimeout == "" {
+		envShutdownTimeout = "10s"
+	}
+	parsedShutdownTimeout, err := time.ParseDuration(envShutdownTimeout)
+	if err != nil {
+		panic(errors.Join(errors.New(fmt.Sprintf("could not parse value of env var %s", ENV_SHUTDOWN_TIMEOUT)), err))
+	}
+
+	envAdminListener := os.Getenv(ENV_ADMIN_LISTENER)
+	if envAdminListener != "" {
+		if _, _, err := net.SplitHostPort(envAdminListener); err != nil {
+			panic(errors.Join(errors.New(fmt.Sprintf("could not parse value of env var %s", ENV_ADMIN_LISTENER)), err))
+		}
+	}
+
+	// At most one secret can be read from stdin.
+	envKeyPassphraseFile := os.Getenv(ENV_KEY_PASSPHRASE_FILE)
+	envAdminKeyFile := os.Getenv(ENV_ADMIN_KEY_FILE)
+	envYggIdentityFile := os.Getenv(ENV_YGG_IDENTITY_FILE)
+	fromStdin := []string{}
+	for name, value := range map[string]string{
+		ENV_KEY_PASSPHRASE_FILE: envKeyPassphraseFile,
+		ENV_ADMIN_KEY_FILE:      envAdminKeyFile,
+		ENV_YGG_IDENTITY_FILE:   envYggIdentityFile,
+	} {
+		if value == keyfile.KEY_FILE_STDIN {
+			fromStdin = append(fromStdin, name)
+		}
+	}
+	if len(fromStdin) > 1 {
+		sort.Strings(fromStdin)
+		panic(errors.New(fmt.Sprintf("only one of %s can be read from stdin", strings.Join(fromStdin, ", "))))
+	}
+	passphrase := keyfile.PassphraseFile(envKeyPassphraseFile)
+
+	var parsedAdminKey *memguard.Enclave
+	if envAdminKeyFile != "" {
+		parsedAdminKey, err = keyfile.LoadKeyFile(envAdminKeyFile, passphrase)
+		if err != nil {
+			panic(errors.Join(errors.New(fmt.Sprintf("could not load admin key from file in env var %s", ENV_ADMIN_KEY_FILE)), err))
+		}
+	}
+
+	var parsedYggIdentity *memguard.Enclave
 	envYggIdentity := os.Getenv(ENV_YGG_IDENTITY)
-	if envYggIdentity == "" {
+	if envYggIdentity != "" && envYggIdentityFile != "" {
+		panic(errors.New(fmt.Sprintf("%s and %s cannot be used together", ENV_YGG_IDENTITY, ENV_YGG_IDENTITY_FILE)))
+	} else if envYggIdentityFile != "" {
+		parsedYggIdentity, err = keyfile.LoadKeyFile(envYggIdentityFile, passphrase)
+		if err != nil {
+			panic(errors.Join(errors.New(fmt.Sprintf("could not load yggdrasil identity from file in env var %s", ENV_YGG_IDENTITY_FILE)), err))
+		}
+	} else if envYggIdentity != "" {
+		decodedYggIdentity, err := hex.DecodeString(envYggIdentity)
+		if err != nil {
+			panic(errors.Join(errors.New(fmt.Sprintf("could not parse value of env var %s", ENV_YGG_IDENTITY)), err))
+		}
+		if len(decodedYggIdentity) != ed25519.PrivateKeySize {
+			panic(errors.New(fmt.Sprintf("could not parse value of env var %s, incorrect private key size", ENV_YGG_IDENTITY)))
+		}
+		parsedYggIdentity = memguard.NewEnclave(decodedYggIdentity)
+		// Don't leave the key lying around where child processes and crash
+		// dumps can see it.
+		os.Unsetenv(ENV_YGG_IDENTITY)
+	} else {
 		panic(errors.New("please define an yggdrasil identity"))
 	}
-	parsedYggIdentity, err := hex.DecodeString(envYggIdentity)
+	yggIdentity, err := parsedYggIdentity.Open()
 	if err != nil {
-		panic(errors.Join(errors.New(fmt.Sprintf("could not parse value of env var %s", ENV_YGG_IDENTITY)), err))
+		panic(errors.Join(errors.New("could not open yggdrasil identity enclave"), err))
+	}
+	parsedYggPublicKey := ed25519.PrivateKey(yggIdentity.Bytes()).Public().(ed25519.PublicKey)
+	yggIdentity.Destroy()
+
+	var parsedAdminPubKey ed25519.PublicKey
+	envAdminPubKey := os.Getenv(ENV_ADMIN_PUBLIC_KEY)
+	if envAdminPubKey != "" {
+		parsedAdminPubKey, err = hex.DecodeString(envAdminPubKey)
+		if err != nil {
+			panic(errors.Join(errors.New(fmt.Sprintf("could not parse value of env var %s", ENV_ADMIN_PUBLIC_KEY)), err))
+		}
+		if len(parsedAdminPubKey) != ed25519.PublicKeySize {
+			panic(errors.New(fmt.Sprintf("could not parse value of env var %s, incorrect public key size", ENV_ADMIN_PUBLIC_KEY)))
+		}
+	}
+	if parsedAdminKey != nil {
+		adminKey, err := parsedAdminKey.Open()
+		if err != nil {
+			panic(errors.Join(errors.New("could not open admin key enclave"), err))
+		}
+		derivedAdminPubKey := ed25519.PrivateKey(adminKey.Bytes()).Public().(ed25519.PublicKey)
+		adminKey.Destroy()
+		if parsedAdminPubKey != nil && !parsedAdminPubKey.Equal(derivedAdminPubKey) {
+			panic(errors.New(fmt.Sprintf("%s does not match the key in %s", ENV_ADMIN_PUBLIC_KEY, ENV_ADMIN_KEY_FILE)))
+		}
+		parsedAdminPubKey = derivedAdminPubKey
+	}
+
+	var parsedYggAuthorization []byte
+	envYggAuthorization := os.Getenv(ENV_YGG_AUTHORIZATION)
+	if envYggAuthorization != "" {
+		parsedYggAuthorization, err = hex.DecodeString(envYggAuthorization)
+		if err != nil {
+			panic(errors.Join(errors.New(fmt.Sprintf("could not parse value of env var %s", ENV_YGG_AUTHORIZATION)), err))
+		}
+		if parsedAdminPubKey == nil {
+			panic(errors.New(fmt.Sprintf("%s requires %s or %s", ENV_YGG_AUTHORIZATION, ENV_ADMIN_PUBLIC_KEY, ENV_ADMIN_KEY_FILE)))
+		}
+		if !radio.VerifyNodeAuthorization(parsedAdminPubKey, parsedYggPublicKey, parsedYggAuthorization) {
+			panic(errors.New(fmt.Sprintf("value of env var %s is not an authorization of this node's identity by the admin key", ENV_YGG_AUTHORIZATION)))
+		}
+	}
+
+	// A standard yggdrasil.conf, with the env vars taking precedence over it.
+	var parsedYggConfig radio.NodeOptions
+	envYggConfigFile := os.Getenv(ENV_YGG_CONFIG_FILE)
+	if envYggConfigFile != "" {
+		yggConfig, err := radio.LoadNodeConfig(envYggConfigFile)
+		if err != nil {
+			panic(errors.Join(errors.New(fmt.Sprintf("could not load yggdrasil configuration from file in env var %s", ENV_YGG_CONFIG_FILE)), err))
+		}
+		parsedYggConfig = radio.NodeOptionsFromConfig(yggConfig)
+		memguard.WipeBytes(yggConfig.PrivateKey)
+		memguard.WipeBytes(parsedYggConfig.PrivateKey)
+		parsedYggConfig.PrivateKey = nil
 	}
 
 	var parsedYggStaticPeers []string
 	envYggStaticPeers := os.Getenv(ENV_YGG_STATIC_PEERS)
 	if envYggStaticPeers == "" {
-		parsedYggStaticPeers = []string{}
+		parsedYggStaticPeers = slices.Clone(parsedYggConfig.Peers)
 	} else {
 		parsedYggStaticPeers = strings.Split(envYggStaticPeers, ",")
 	}
@@ -73,7 +290,7 @@ func MakeConf() conf {
 	var parsedYggListeners []string
 	envYggListeners := os.Getenv(ENV_YGG_LISTENERS)
 	if envYggListeners == "" {
-		parsedYggListeners = []string{}
+		parsedYggListeners = slices.Clone(parsedYggConfig.Listen)
 	} else {
 		parsedYggListeners = strings.Split(envYggListeners, ",")
 	}
@@ -84,6 +301,51 @@ func MakeConf() conf {
 		}
 	}
 
+	envYggReadyPeers := os.Getenv(ENV_YGG_READY_PEERS)
+	if envYggReadyPeers == "" {
+		envYggReadyPeers = "1"
+	}
+	parsedYggReadyPeers, err := strconv.Atoi(envYggReadyPeers)
+	if err != nil || parsedYggReadyPeers < 0 {
+		panic(errors.Join(errors.New(fmt.Sprintf("could not parse value of env var %s", ENV_YGG_READY_PEERS)), err))
+	}
+
+	parsedYggAllowedPeers := slices.Clone(parsedYggConfig.AllowedPublicKeys)
+	envYggAllowedPeers := os.Getenv(ENV_YGG_ALLOWED_PEERS)
+	if envYggAllowedPeers != "" {
+		parsedYggAllowedPeers = strings.Split(envYggAllowedPeers, ",")
+	}
+	if err := (radio.PeerFilter{Allow: parsedYggAllowedPeers}).Validate(); err != nil {
+		panic(errors.Join(errors.New(fmt.Sprintf("could not parse value of env var %s", ENV_YGG_ALLOWED_PEERS)), err))
+	}
+
+	parsedYggDeniedPeers := []string{}
+	envYggDeniedPeers := os.Getenv(ENV_YGG_DENIED_PEERS)
+	if envYggDeniedPeers != "" {
+		parsedYggDeniedPeers = strings.Split(envYggDeniedPeers, ",")
+	}
+	if err := (radio.PeerFilter{Allow: parsedYggAllowedPeers, Deny: parsedYggDeniedPeers}).Validate(); err != nil {
+		panic(errors.Join(errors.New(fmt.Sprintf("could not parse value of env var %s", ENV_YGG_DENIED_PEERS)), err))
+	}
+
+	envBridgeMaxConns := os.Getenv(ENV_BRIDGE_MAX_CONNS)
+	if envBridgeMaxConns == "" {
+		envBridgeMaxConns = "1024"
+	}
+	parsedBridgeMaxConns, err := strconv.Atoi(envBridgeMaxConns)
+	if err != nil || parsedBridgeMaxConns < 0 {
+		panic(errors.Join(errors.New(fmt.Sprintf("could not parse value of env var %s", ENV_BRIDGE_MAX_CONNS)), err))
+	}
+
+	envBridgeMaxConnsPerPeer := os.Getenv(ENV_BRIDGE_MAX_CONNS_PER_PEER)
+	if envBridgeMaxConnsPerPeer == "" {
+		envBridgeMaxConnsPerPeer = "8"
+	}
+	parsedBridgeMaxConnsPerPeer, err := strconv.Atoi(envBridgeMaxConnsPerPeer)
+	if err != nil || parsedBridgeMaxConnsPerPeer < 0 {
+		panic(errors.Join(errors.New(fmt.Sprintf("could not parse value of env var %s", ENV_BRIDGE_MAX_CONNS_PER_PEER)), err))
+	}
+
 	envNatsAdminUser := os.Getenv(ENV_NATS_ADMIN_USER)
 	envNatsAdminPass := os.Getenv(ENV_NATS_ADMIN_PASS)
 	if envNatsAdminUser == "" || envNatsAdminPass == "" {
@@ -92,13 +354,197 @@ func MakeConf() conf {
 
 	envNatsListener := os.Getenv(ENV_NATS_LISTENER)
 
+	parsedNatsOperators := []operator{}
+	envNatsOperators := os.Getenv(ENV_NATS_OPERATORS)
+	if envNatsOperators != "" {
+		parsedNatsOperators, err = loadOperators(envNatsOperators)
+		if err != nil {
+			panic(errors.Join(errors.New(fmt.Sprintf("could not load operators from file in env var %s", ENV_NATS_OPERATORS)), err))
+		}
+	}
+	for _, op := range parsedNatsOperators {
+		if op.Name == envNatsAdminUser {
+			panic(errors.New(fmt.Sprintf("operator name %s clashes with the admin username", op.Name)))
+		}
+	}
+
+	envNatsStoreDir := os.Getenv(ENV_NATS_STORE_DIR)
+
+	envNatsServerName := os.Getenv(ENV_NATS_SERVER_NAME)
+	if envNatsServerName == "" {
+		envNatsServerName, err = os.Hostname()
+		if err != nil {
+			panic(errors.Join(errors.New(fmt.Sprintf("could not determine hostname, please define env var %s", ENV_NATS_SERVER_NAME)), err))
+		}
+	}
+
+	envNatsJetStreamDomain := os.Getenv(ENV_NATS_JETSTREAM_DOMAIN)
+
+	envNatsClusterName := os.Getenv(ENV_NATS_CLUSTER_NAME)
+	if envNatsClusterName == "" {
+		envNatsClusterName = NATS_APP_ACCOUNT
+	}
+
+	envNatsClusterListener := os.Getenv(ENV_NATS_CLUSTER_LISTENER)
+	if envNatsClusterListener != "" {
+		if _, _, err := parseHostPort(envNatsClusterListener); err != nil {
+			panic(errors.Join(errors.New(fmt.Sprintf("could not parse value of env var %s", ENV_NATS_CLUSTER_LISTENER)), err))
+		}
+	}
+
+	parsedNatsClusterRoutes, err := parseNatsURLs(os.Getenv(ENV_NATS_CLUSTER_ROUTES))
+	if err != nil {
+		panic(errors.Join(errors.New(fmt.Sprintf("could not parse value of env var %s", ENV_NATS_CLUSTER_ROUTES)), err))
+	}
+	if len(parsedNatsClusterRoutes) != 0 && envNatsClusterListener == "" {
+		panic(errors.New(fmt.Sprintf("%s requires %s", ENV_NATS_CLUSTER_ROUTES, ENV_NATS_CLUSTER_LISTENER)))
+	}
+
+	envNatsClusterUser := os.Getenv(ENV_NATS_CLUSTER_USER)
+	envNatsClusterPass := os.Getenv(ENV_NATS_CLUSTER_PASS)
+	if envNatsClusterListener != "" && (envNatsClusterUser == "" || envNatsClusterPass == "") {
+		panic(errors.New(fmt.Sprintf("please define %s and %s to protect cluster routes", ENV_NATS_CLUSTER_USER, ENV_NATS_CLUSTER_PASS)))
+	}
+
+	envNatsLeafnodeListener := os.Getenv(ENV_NATS_LEAFNODE_LISTENER)
+	if envNatsLeafnodeListener != "" {
+		if _, _, err := parseHostPort(envNatsLeafnodeListener); err != nil {
+			panic(errors.Join(errors.New(fmt.Sprintf("could not parse value of env var %s", ENV_NATS_LEAFNODE_LISTENER)), err))
+		}
+	}
+
+	parsedNatsLeafnodeRemotes, err := parseNatsURLs(os.Getenv(ENV_NATS_LEAFNODE_REMOTES))
+	if err != nil {
+		panic(errors.Join(errors.New(fmt.Sprintf("could not parse value of env var %s", ENV_NATS_LEAFNODE_REMOTES)), err))
+	}
+
+	envNatsTLSCert := os.Getenv(ENV_NATS_TLS_CERT)
+	envNatsTLSKey := os.Getenv(ENV_NATS_TLS_KEY)
+	if (envNatsTLSCert == "") != (envNatsTLSKey == "") {
+		panic(errors.New(fmt.Sprintf("%s and %s must be defined together", ENV_NATS_TLS_CERT, ENV_NATS_TLS_KEY)))
+	}
+
+	envNatsTLSClientCA := os.Getenv(ENV_NATS_TLS_CLIENT_CA)
+
+	envNatsTLSSelfSigned := os.Getenv(ENV_NATS_TLS_SELF_SIGNED)
+	if envNatsTLSSelfSigned == "" {
+		envNatsTLSSelfSigned = "false"
+	}
+	parsedNatsTLSSelfSigned, err := strconv.ParseBool(envNatsTLSSelfSigned)
+	if err != nil {
+		panic(errors.Join(errors.New(fmt.Sprintf("could not parse value of env var %s", ENV_NATS_TLS_SELF_SIGNED)), err))
+	}
+	if parsedNatsTLSSelfSigned && envNatsTLSCert != "" {
+		panic(errors.New(fmt.Sprintf("%s cannot be used together with %s", ENV_NATS_TLS_SELF_SIGNED, ENV_NATS_TLS_CERT)))
+	}
+	if envNatsTLSClientCA != "" && envNatsTLSCert == "" {
+		panic(errors.New(fmt.Sprintf("%s requires %s and %s", ENV_NATS_TLS_CLIENT_CA, ENV_NATS_TLS_CERT, ENV_NATS_TLS_KEY)))
+	}
+
+	envNatsClusterTLSCA := os.Getenv(ENV_NATS_CLUSTER_TLS_CA)
+	if envNatsClusterTLSCA != "" && (envNatsClusterListener == "" || envNatsTLSCert == "") {
+		panic(errors.New(fmt.Sprintf("%s requires %s and %s", ENV_NATS_CLUSTER_TLS_CA, ENV_NATS_CLUSTER_LISTENER, ENV_NATS_TLS_CERT)))
+	}
+
+	envNatsLeafnodeTLSCA := os.Getenv(ENV_NATS_LEAFNODE_TLS_CA)
+	if envNatsLeafnodeTLSCA != "" && envNatsLeafnodeListener == "" && len(parsedNatsLeafnodeRemotes) == 0 {
+		panic(errors.New(fmt.Sprintf("%s requires %s or %s", ENV_NATS_LEAFNODE_TLS_CA, ENV_NATS_LEAFNODE_LISTENER, ENV_NATS_LEAFNODE_REMOTES)))
+	}
+	if envNatsLeafnodeTLSCA != "" && envNatsLeafnodeListener != "" && envNatsTLSCert == "" {
+		panic(errors.New(fmt.Sprintf("%s with %s requires %s", ENV_NATS_LEAFNODE_TLS_CA, ENV_NATS_LEAFNODE_LISTENER, ENV_NATS_TLS_CERT)))
+	}
+
+	envNatsWebsocketListener := os.Getenv(ENV_NATS_WS_LISTENER)
+
+	envEventsMaxAge := os.Getenv(ENV_EVENTS_MAX_AGE)
+	if envEventsMaxAge == "" {
+		envEventsMaxAge = "720h"
+	}
+	parsedEventsMaxAge, err := time.ParseDuration(envEventsMaxAge)
+	if err != nil {
+		panic(errors.Join(errors.New(fmt.Sprintf("could not parse value of env var %s", ENV_EVENTS_MAX_AGE)), err))
+	}
+
+	envEventsMaxBytes := os.Getenv(ENV_EVENTS_MAX_BYTES)
+	if envEventsMaxBytes == "" {
+		envEventsMaxBytes = "-1"
+	}
+	parsedEventsMaxBytes, err := strconv.ParseInt(envEventsMaxBytes, 10, 64)
+	if err != nil {
+		panic(errors.Join(errors.New(fmt.Sprintf("could not parse value of env var %s", ENV_EVENTS_MAX_BYTES)), err))
+	}
+
+	envEventsReplicas := os.Getenv(ENV_EVENTS_REPLICAS)
+	if envEventsReplicas == "" {
+		envEventsReplicas = "1"
+	}
+	parsedEventsReplicas, err := strconv.Atoi(envEventsReplicas)
+	if err != nil || parsedEventsReplicas < 1 || parsedEventsReplicas > 5 {
+		panic(errors.Join(errors.New(fmt.Sprintf("could not parse value of env var %s, must be between 1 and 5", ENV_EVENTS_REPLICAS)), err))
+	}
+	if parsedEventsReplicas > 1 && (envNatsClusterListener == "" || envNatsStoreDir == "") {
+		panic(errors.New(fmt.Sprintf("%s above 1 requires %s and %s", ENV_EVENTS_REPLICAS, ENV_NATS_CLUSTER_LISTENER, ENV_NATS_STORE_DIR)))
+	}
+
+	parsedWebhooks := []webhookConfig{}
+	envWebhooks := os.Getenv(ENV_WEBHOOKS)
+	if envWebhooks != "" {
+		parsedWebhooks, err = loadWebhooks(envWebhooks)
+		if err != nil {
+			panic(errors.Join(errors.New(fmt.Sprintf("could not load webhooks from file in env var %s", ENV_WEBHOOKS)), err))
+		}
+	}
+
 	return conf{
-		Debug:          parsedDebug,
-		YggIdentity:    parsedYggIdentity,
+		Debug:           parsedDebug,
+		ShutdownTimeout: parsedShutdownTimeout,
+		AdminListener:   envAdminListener,
+
+		AdminKey:    parsedAdminKey,
+		AdminPubKey: parsedAdminPubKey,
+
+		YggIdentity:      parsedYggIdentity,
+		YggPublicKey:     parsedYggPublicKey,
+		YggAuthorization: parsedYggAuthorization,
+
 		YggStaticPeers: parsedYggStaticPeers,
 		YggListeners:   parsedYggListeners,
-		NatsAdminUser:  envNatsAdminUser,
-		NatsAdminPass:  envNatsAdminPass,
-		NatsListener:   envNatsListener,
+		YggReadyPeers:  parsedYggReadyPeers,
+
+		YggAllowedPeers: parsedYggAllowedPeers,
+		YggDeniedPeers:  parsedYggDeniedPeers,
+		YggConfig:       parsedYggConfig,
+
+		BridgeMaxConns:        parsedBridgeMaxConns,
+		BridgeMaxConnsPerPeer: parsedBridgeMaxConnsPerPeer,
+
+		NatsAdminUser: envNatsAdminUser,
+		NatsAdminPass: envNatsAdminPass,
+		NatsListener:  envNatsListener,
+		NatsOperators: parsedNatsOperators,
+		NatsStoreDir:  envNatsStoreDir,
+
+		NatsServerName:       envNatsServerName,
+		NatsJetStreamDomain:  envNatsJetStreamDomain,
+		NatsClusterName:      envNatsClusterName,
+		NatsClusterListener:  envNatsClusterListener,
+		NatsClusterRoutes:    parsedNatsClusterRoutes,
+		NatsClusterUser:      envNatsClusterUser,
+		NatsClusterPass:      envNatsClusterPass,
+		NatsLeafnodeListener: envNatsLeafnodeListener,
+		NatsLeafnodeRemotes:  parsedNatsLeafnodeRemotes,
+		NatsClusterTLSCA:     envNatsClusterTLSCA,
+		NatsLeafnodeTLSCA:    envNatsLeafnodeTLSCA,
+
+		NatsTLSCert:           envNatsTLSCert,
+		NatsTLSKey:            envNatsTLSKey,
+		NatsTLSClientCA:       envNatsTLSClientCA,
+		NatsTLSSelfSigned:     parsedNatsTLSSelfSigned,
+		NatsWebsocketListener: envNatsWebsocketListener,
+
+		EventsMaxAge:   parsedEventsMaxAge,
+		EventsMaxBytes: parsedEventsMaxBytes,
+		EventsReplicas: parsedEventsReplicas,
+		Webhooks:       parsedWebhooks,
 	}
 }
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"dev.l1qu1d.net/wraith-labs/wraith_module_comosum/radio"
+	"github.com/nats-io/nats-server/v2/server"
+)
+
+// A single named check contributing to health or readiness.
+type healthCheck struct {
+	name  string
+	check func() error
+}
+
+// Build a handler which runs all given checks and reports the results as JSON.
+// Responds with 200 if all checks pass and 503 otherwise.
+func healthHandler(checks ...healthCheck) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		healthy := true
+		results := map[string]string{}
+		for _, c := range checks {
+			if err := c.check(); err != nil {
+				healthy = false
+				results[c.name] = err.Error()
+			} else {
+				results[c.name] = "ok"
+			}
+		}
+
+		res.Header().Set("Content-Type", "application/json")
+		res.Header().Set("Cache-Control", "no-store")
+		if healthy {
+			res.WriteHeader(http.StatusOK)
+		} else {
+			res.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_ = json.NewEncoder(res).Encode(results)
+	})
+}
+
+// Check that the NATS server is accepting connections.
+func checkNats(ns *server.Server) healthCheck {
+	return healthCheck{"nats", func() error {
+		if !ns.Running() {
+			return errors.New("not running")
+		}
+		if !ns.ReadyForConnections(100 * time.Millisecond) {
+			return errors.New("not ready for connections")
+		}
+		return nil
+	}}
+}
+
+// Check that JetStream is available on the NATS server.
+func checkJetStream(ns *server.Server) healthCheck {
+	return healthCheck{"jetstream", func() error {
+		if !ns.JetStreamEnabled() {
+			return errors.New("not enabled")
+		}
+		if !ns.JetStreamIsCurrent() {
+			return errors.New("not current")
+		}
+		return nil
+	}}
+}
+
+// Check that the Yggdrasil node is running. Whether it can be reached is left
+// to checkYggdrasilReachable, since losing peers is no reason for a restart.
+func checkYggdrasil(n *radio.Node) healthCheck {
+	return healthCheck{"yggdrasil", func() error {
+		if state := n.State(); state != radio.NODE_STATE_RUNNING {
+			return fmt.Errorf("node is %s", state)
+		}
+		return nil
+	}}
+}
+
+// Check that the Yggdrasil node can be reached, either through a peer that is
+// up or a listener accepting peerings.
+func checkYggdrasilReachable(n *radio.Node) healthCheck {
+	return healthCheck{"yggdrasil_reachable", func() error {
+		if len(n.Listeners()) != 0 {
+			return nil
+		}
+		for _, peer := range n.Peers() {
+			if peer.Up {
+				return nil
+			}
+		}
+		return errors.New("no peers up and no listeners")
+	}}
+}
+
+// Check that the Yggdrasil node has at least the given number of peers up.
+func checkYggdrasilPeers(n *radio.Node, min int) healthCheck {
+	return healthCheck{"yggdrasil_peers", func() error {
+		up := 0
+		for _, peer := range n.Peers() {
+			if peer.Up {
+				up++
+			}
+		}
+		if up < min {
+			return fmt.Errorf("%d peer(s) up, need at least %d", up, min)
+		}
+		return nil
+	}}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"dev.l1qu1d.net/wraith-labs/wraith_module_comosum/cmd/internal/wire"
+	"dev.l1qu1d.net/wraith-labs/wraith_module_comosum/radio"
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	// The name of the JetStream stream client events are recorded in.
+	EVENTS_STREAM = "COMOSUM_EVENTS"
+
+	// Limits on the number of events returned by a single history query.
+	HISTORY_DEFAULT_LIMIT = 100
+	HISTORY_MAX_LIMIT     = 10000
+
+	// How long to keep trying to set up the events stream while a cluster is
+	// forming.
+	HISTORY_SETUP_TIMEOUT = time.Minute
+
+	// How long to wait for the next matching event before concluding that there
+	// are none left.
+	HISTORY_FETCH_TIMEOUT = time.Second
+
+	// How many history queries may run at once. Further queries wait.
+	HISTORY_MAX_CONCURRENT_QUERIES = 8
+)
+
+// Records client events into a JetStream stream and answers queries about them.
+type history struct {
+	nc     *nats.Conn
+	js     nats.JetStreamContext
+	logger *slog.Logger
+	sub    *nats.Subscription
+
+	// Limits and keeps track of the queries in progress.
+	slots chan struct{}
+	wg    sync.WaitGroup
+}
+
+// Create or update the events stream with the given retention limits and
+// number of replicas, and start answering history queries.
+func startHistory(nc *nats.Conn, logger *slog.Logger, maxAge time.Duration, maxBytes int64, replicas int) (*history, error) {
+	js, err := nc.JetStream()
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &nats.StreamConfig{
+		Name:      EVENTS_STREAM,
+		Subjects:  []string{radio.EventSubject("", "")},
+		Retention: nats.LimitsPolicy,
+		Storage:   nats.FileStorage,
+		MaxAge:    maxAge,
+		MaxBytes:  maxBytes,
+		Discard:   nats.DiscardOld,
+		Replicas:  replicas,
+	}
+	if _, err := js.StreamInfo(EVENTS_STREAM); errors.Is(err, nats.ErrStreamNotFound) {
+		_, err = js.AddStream(cfg)
+		if err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	} else if _, err = js.UpdateStream(cfg); err != nil {
+		return nil, err
+	}
+
+	h := &history{
+		nc:     nc,
+		js:     js,
+		logger: logger,
+		slots:  make(chan struct{}, HISTORY_MAX_CONCURRENT_QUERIES),
+	}
+	// Use a queue group so only one instance answers when clustered. Queries
+	// can take a while, so each gets its own goroutine rather than holding up
+	// the ones behind it.
+	h.sub, err = nc.QueueSubscribe(wire.HISTORY_QUERY_SUBJECT, PRODUCT_NAME, func(msg *nats.Msg) {
+		h.wg.Add(1)
+		go func() {
+			defer h.wg.Done()
+			h.slots <- struct{}{}
+			defer func() { <-h.slots }()
+			h.handle(msg)
+		}()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
+
+// Stop answering history queries and wait for those in progress.
+func (h *history) Close() error {
+	err := h.sub.Unsubscribe()
+	h.wg.Wait()
+	return err
+}
+
+func (h *history) handle(msg *nats.Msg) {
+	result := wire.HistoryResult{Events: []radio.Event{}}
+
+	query := wire.HistoryQuery{}
+	if err := json.Unmarshal(msg.Data, &query); err != nil {
+		result.Error = "malformed query: " + err.Error()
+	} else if result.Events, result.Truncated, err = h.query(query); err != nil {
+		result.Error = err.Error()
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		h.logger.Error("failed to marshal history result", "err", err)
+		return
+	}
+	if err := msg.Respond(data); err != nil {
+		h.logger.Warn("failed to respond to history query", "err", err)
+	}
+}
+
+// Find events matching the given query, oldest first.
+func (h *history) query(q wire.HistoryQuery) ([]radio.Event, bool, error) {
+	// The client and op end up in the subject filter, so they must not
+	// contain anything that would change its meaning.
+	if q.Client != "" && !isSubjectToken(q.Client) {
+		return nil, false, fmt.Errorf("invalid client %q", q.Client)
+	}
+	if q.Op != "" && !isSubjectToken(q.Op) {
+		return nil, false, fmt.Errorf("invalid op %q", q.Op)
+	}
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = HISTORY_DEFAULT_LIMIT
+	}
+	if limit > HISTORY_MAX_LIMIT {
+		limit = HISTORY_MAX_LIMIT
+	}
+
+	opts := []nats.SubOpt{nats.BindStream(EVENTS_STREAM), nats.OrderedConsumer()}
+	if q.Since.IsZero() {
+		opts = append(opts, nats.DeliverAll())
+	} else {
+		opts = append(opts, nats.StartTime(q.Since))
+	}
+	sub, err := h.js.SubscribeSync(radio.EventSubject(q.Client, q.Op), opts...)
+	if err != nil {
+		return nil, false, err
+	}
+	defer sub.Unsubscribe()
+
+	// Don't wait for events if there are none to begin with.
+	if info, err := sub.ConsumerInfo(); err == nil && info.NumPending == 0 {
+		return []radio.Event{}, false, nil
+	}
+
+	events := []radio.Event{}
+	for {
+		msg, err := sub.NextMsg(HISTORY_FETCH_TIMEOUT)
+		if errors.Is(err, nats.ErrTimeout) {
+			// Nothing (more) matches.
+			return events, false, nil
+		} else if err != nil {
+			return nil, false, err
+		}
+
+		meta, err := msg.Metadata()
+		if err != nil {
+			return nil, false, err
+		}
+		if !q.Until.IsZero() && meta.Timestamp.After(q.Until) {
+			return events, false, nil
+		}
+
+		event := radio.Event{}
+		if err := json.Unmarshal(msg.Data, &event); err != nil {
+			h.logger.Debug("skipping malformed event in history", "seq", meta.Sequence.Stream, "err", err)
+		} else if q.Key == "" || slices.Contains(event.Keys, q.Key) {
+			if len(events) == limit {
+				return events, true, nil
+			}
+			events = append(events, event)
+		}
+
+		if meta.NumPending == 0 {
+			return events, false, nil
+		}
+	}
+}
+
+// Whether s can be used as a single token of a NATS subject, without
+// wildcards.
+func isSubjectToken(s string) bool {
+	return s != "" && !strings.ContainsAny(s, ". *>\t\r\n")
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+
+	"dev.l1qu1d.net/wraith-labs/wraith_module_comosum/radio"
+	"github.com/awnumar/memguard"
+)
+
+// Sign an authorization of this node's identity with the admin key.
+func authorizeSelf(adminKey *memguard.Enclave, nodeKey ed25519.PublicKey) ([]byte, error) {
+	key, err := adminKey.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer key.Destroy()
+
+	// Go's crypto code can't sign with keys outside the Go heap, so sign with
+	// a short-lived copy.
+	priv := ed25519.PrivateKey(bytes.Clone(key.Bytes()))
+	defer memguard.WipeBytes(priv)
+
+	return radio.AuthorizeNode(priv, nodeKey)
+}
@@ -1,37 +1,72 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
 	"errors"
 	"fmt"
-	"io"
+	"log/slog"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"strconv"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"dev.l1qu1d.net/wraith-labs/wraith_module_comosum/radio"
-	"github.com/gologme/log"
+	"github.com/awnumar/memguard"
 	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
 )
 
-const PRODUCT_NAME = "wmc3"
+const (
+	PRODUCT_NAME = "wmc3"
+
+	// The NATS account shared by the admin user, operators and clients.
+	NATS_APP_ACCOUNT = "comosum"
+)
 
 func main() {
-	// Set up logging.
-	logger := log.New(os.Stdout, fmt.Sprintf("%s ", PRODUCT_NAME), log.Flags())
-	logger.EnableLevelsByNumber(5)
-	yggLogger := log.New(os.Stdout, fmt.Sprintf("%s-yggdrasil ", PRODUCT_NAME), log.Flags())
+	// Set up logging. Yggdrasil is chatty, so only its warnings are shown
+	// unless debugging.
+	logLevel, yggLogLevel := new(slog.LevelVar), new(slog.LevelVar)
+	yggLogLevel.Set(slog.LevelWarn)
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: logLevel}))
+	yggLogger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: yggLogLevel})).With("component", "yggdrasil")
+
+	logger.Info("starting " + PRODUCT_NAME)
 
-	logger.Infof("starting %s", PRODUCT_NAME)
+	// Wipe keys from memory on the way out, including when panicking.
+	defer memguard.Purge()
 
 	// Parse configuration.
 	c := MakeConf()
 
 	if c.Debug {
-		yggLogger = log.New(os.Stdout, PRODUCT_NAME, log.Flags())
-		logger.EnableLevelsByNumber(10)
+		logLevel.Set(slog.LevelDebug)
+		yggLogLevel.Set(slog.LevelDebug)
+	}
+
+	// Clients only trust this node if its identity is the admin key or the
+	// admin key has authorized it.
+	switch {
+	case c.AdminPubKey != nil && c.YggPublicKey.Equal(c.AdminPubKey):
+		logger.Warn("the Yggdrasil identity is the admin key; consider using a separate, authorized identity")
+	case c.YggAuthorization != nil:
+		logger.Info("Yggdrasil identity is authorized by the admin key", "identity", hex.EncodeToString(c.YggPublicKey), "admin", hex.EncodeToString(c.AdminPubKey))
+	case c.AdminKey != nil:
+		authorization, err := authorizeSelf(c.AdminKey, c.YggPublicKey)
+		if err != nil {
+			panic(errors.Join(errors.New("failed to authorize Yggdrasil identity"), err))
+		}
+		c.YggAuthorization = authorization
+		logger.Info("authorized Yggdrasil identity with the admin key", "identity", hex.EncodeToString(c.YggPublicKey), "admin", hex.EncodeToString(c.AdminPubKey), "authorization", hex.EncodeToString(c.YggAuthorization))
+	default:
+		logger.Warn("Yggdrasil identity is not authorized by an admin key; clients will only accept it if it is their admin key", "identity", hex.EncodeToString(c.YggPublicKey))
 	}
 
 	// Set up clean exit handler.
@@ -46,20 +81,27 @@ func main() {
 
 	noExternalListener := c.NatsListener == ""
 	systemAccount := server.NewAccount("system")
+	// JetStream cannot be used in the system account, so everyone using the
+	// C2 lives in a separate account.
+	appAccount := server.NewAccount(NATS_APP_ACCOUNT)
 	opts := &server.Options{
-		DontListen:    noExternalListener,
+		DontListen: noExternalListener,
+		// We handle signals ourselves to shut down cleanly.
+		NoSigs:        true,
 		SystemAccount: systemAccount.Name,
 		Accounts: []*server.Account{
 			systemAccount,
+			appAccount,
 		},
 		Users: []*server.User{
 			{
 				Username: c.NatsAdminUser,
 				Password: c.NatsAdminPass,
-				Account:  systemAccount,
+				Account:  appAccount,
 			},
 		},
 	}
+	applyOperators(opts, appAccount, c.NatsOperators)
 	if !noExternalListener {
 		listenHost, listenPort, err := net.SplitHostPort(c.NatsListener)
 		if err != nil {
@@ -72,11 +114,51 @@ func main() {
 			panic(errors.Join(errors.New("failed to parse NATS listen port"), err))
 		}
 	}
+	if c.NatsStoreDir != "" {
+		opts.JetStream = true
+		opts.StoreDir = c.NatsStoreDir
+	} else {
+		logger.Warn("NATS store directory is not configured; JetStream and event history are disabled")
+	}
+	tlsConfig, err := makeTLSConfig(c)
+	if err != nil {
+		panic(errors.Join(errors.New("failed to set up NATS TLS"), err))
+	}
+	if tlsConfig != nil {
+		opts.TLS = true
+		opts.TLSConfig = tlsConfig
+		opts.TLSVerify = c.NatsTLSClientCA != ""
+		opts.TLSTimeout = 5
+	} else if !noExternalListener {
+		logger.Warn("NATS TLS is not configured; external listener is plaintext")
+	}
+	if err := applyCluster(opts, c, tlsConfig); err != nil {
+		panic(err)
+	}
+	if c.NatsWebsocketListener != "" {
+		listenHost, listenPort, err := net.SplitHostPort(c.NatsWebsocketListener)
+		if err != nil {
+			panic(errors.Join(errors.New("failed to parse NATS websocket listen string"), err))
+		}
+
+		opts.Websocket.Host = listenHost
+		opts.Websocket.Port, err = strconv.Atoi(listenPort)
+		if err != nil {
+			panic(errors.Join(errors.New("failed to parse NATS websocket listen port"), err))
+		}
+		opts.Websocket.TLSConfig = tlsConfig
+		opts.Websocket.NoTLS = tlsConfig == nil
+		if tlsConfig == nil {
+			logger.Warn("NATS TLS is not configured; websocket listener is plaintext")
+		}
+	}
 	ns, err := server.NewServer(opts)
 	if err != nil {
 		panic(errors.Join(errors.New("failed to parse NATS listen port"), err))
 	}
 
+	logger.Info("configured operators in addition to the admin user", "operators", len(c.NatsOperators))
+
 	logger.Info("starting NATS server")
 
 	go ns.Start()
@@ -85,6 +167,63 @@ func main() {
 		panic(errors.New("timeout waiting for NATS server to come up"))
 	}
 
+	if opts.JetStream {
+		acc, err := ns.LookupAccount(NATS_APP_ACCOUNT)
+		if err == nil {
+			err = acc.EnableJetStream(map[string]server.JetStreamAccountLimits{
+				"": {
+					MaxMemory:     -1,
+					MaxStore:      -1,
+					MaxStreams:    -1,
+					MaxConsumers:  -1,
+					MaxAckPending: -1,
+				},
+			})
+		}
+		if err != nil {
+			panic(errors.Join(errors.New("failed to enable JetStream"), err))
+		}
+	}
+
+	// Connect to the NATS server in-process for our own services.
+	ncClosed := make(chan struct{})
+	nc, err := nats.Connect("",
+		nats.InProcessServer(ns),
+		nats.UserInfo(c.NatsAdminUser, c.NatsAdminPass),
+		nats.Name(PRODUCT_NAME),
+		nats.ClosedHandler(func(*nats.Conn) { close(ncClosed) }),
+	)
+	if err != nil {
+		panic(errors.Join(errors.New("failed to connect to NATS server"), err))
+	}
+
+	var h *history
+	if opts.JetStream {
+		// In a cluster, JetStream only becomes available once enough peers
+		// are up to elect a leader, so keep trying for a while.
+		deadline := time.Now().Add(HISTORY_SETUP_TIMEOUT)
+		for {
+			h, err = startHistory(nc, logger, c.EventsMaxAge, c.EventsMaxBytes, c.EventsReplicas)
+			if err == nil || c.NatsClusterListener == "" || time.Now().After(deadline) {
+				break
+			}
+			logger.Debug("event history not ready yet", "err", err)
+			time.Sleep(time.Second)
+		}
+		if err != nil {
+			panic(errors.Join(errors.New("failed to set up event history"), err))
+		}
+	}
+
+	var wh *webhooks
+	if len(c.Webhooks) != 0 {
+		wh, err = startWebhooks(nc, logger, c.Webhooks)
+		if err != nil {
+			panic(errors.Join(errors.New("failed to set up webhooks"), err))
+		}
+		logger.Info("forwarding events to webhooks", "webhooks", len(c.Webhooks))
+	}
+
 	//
 	// Create and start the Yggdrasil node.
 	//
@@ -93,11 +232,42 @@ func main() {
 
 	// Set up Yggdrasil.
 	n := radio.NewNode(yggLogger)
-	n.GenerateConfig(c.YggIdentity, c.YggListeners, c.YggStaticPeers, "none")
+	// Go's crypto code can't sign with keys outside the Go heap, so the node
+	// has to get its own copy of the identity.
+	yggIdentity, err := c.YggIdentity.Open()
+	if err != nil {
+		panic(errors.Join(errors.New("failed to open Yggdrasil identity enclave"), err))
+	}
+	yggOptions := c.YggConfig
+	yggOptions.PrivateKey = ed25519.PrivateKey(bytes.Clone(yggIdentity.Bytes()))
+	yggOptions.Listen = c.YggListeners
+	yggOptions.Peers = c.YggStaticPeers
+	yggOptions.AllowedPublicKeys = c.YggAllowedPeers
+	yggOptions.DeniedPublicKeys = c.YggDeniedPeers
+	err = n.GenerateConfig(yggOptions)
+	yggIdentity.Destroy()
+	if err != nil {
+		panic(errors.Join(errors.New("invalid Yggdrasil node configuration"), err))
+	}
 	if err := n.Run(); err != nil {
 		panic(errors.Join(errors.New("failed to start Yggdrasil node"), err))
 	}
 
+	// Allow operators to manage peers at runtime.
+	pa, err := startPeerAPI(nc, n, c.NatsServerName, logger)
+	if err != nil {
+		panic(errors.Join(errors.New("failed to start peer management API"), err))
+	}
+	// Report inbound peers which the peer filter does not permit, since
+	// Yggdrasil only enforces the filter it started with. This stops along
+	// with the node.
+	go radio.NewPeerSupervisor(n, radio.PeerSupervisorOptions{}).Run(context.Background())
+
+	sa, err := startStatsAPI(nc, n, c.NatsServerName, logger)
+	if err != nil {
+		panic(errors.Join(errors.New("failed to start stats API"), err))
+	}
+
 	yggaddr, _ := n.Address()
 
 	// Set up userspace network stack to handle Yggdrasil packets.
@@ -107,36 +277,124 @@ func main() {
 	}
 
 	// Set up listener for NATS-over-Ygg.
-	listener, _ := s.ListenTCP(&net.TCPAddr{Port: radio.C2_PORT})
+	listener, err := s.ListenTCP(&net.TCPAddr{Port: radio.C2_PORT})
+	if err != nil {
+		panic(errors.Join(errors.New("failed to listen on Yggdrasil netstack"), err))
+	}
+	b := newBridge(listener, ns.InProcessConn, logger, c.BridgeMaxConns, c.BridgeMaxConnsPerPeer)
 	go func() {
-		for {
-			conn, _ := listener.Accept()
-			c, _ := ns.InProcessConn()
-			go io.Copy(conn, c)
-			go io.Copy(c, conn)
+		if err := b.Serve(); err != nil {
+			logger.Error("NATS-over-Yggdrasil bridge stopped", "err", err)
 		}
 	}()
 
-	logger.Infof("listening on nats://[%s]:45235 (yggdrasil)", yggaddr)
+	//
+	// Set up the admin listener.
+	//
+
+	var shuttingDown atomic.Bool
+	var a *adminServer
+	if c.AdminListener != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metricsHandler(b, n, s))
+		mux.Handle("/healthz", healthHandler(
+			checkNats(ns),
+			checkYggdrasil(n),
+		))
+		readyChecks := []healthCheck{
+			checkNats(ns),
+			checkYggdrasil(n),
+			checkYggdrasilReachable(n),
+			checkYggdrasilPeers(n, c.YggReadyPeers),
+			{"shutdown", func() error {
+				if shuttingDown.Load() {
+					return errors.New("shutting down")
+				}
+				return nil
+			}},
+		}
+		if opts.JetStream {
+			readyChecks = append(readyChecks, checkJetStream(ns))
+		}
+		mux.Handle("/readyz", healthHandler(readyChecks...))
+
+		a, err = newAdminServer(c.AdminListener, mux, logger)
+		if err != nil {
+			panic(errors.Join(errors.New("failed to start admin listener"), err))
+		}
+		go a.Serve()
+
+		logger.Info("admin endpoints listening", "url", "http://"+c.AdminListener)
+	}
+
+	logger.Info("listening on Yggdrasil", "url", fmt.Sprintf("nats://[%s]:%d", yggaddr, radio.C2_PORT))
+	natsScheme, wsScheme := "nats", "ws"
+	if tlsConfig != nil {
+		natsScheme, wsScheme = "tls", "wss"
+	}
 	if !noExternalListener {
-		logger.Infof("listening on nats://%s", c.NatsListener)
+		logger.Info("listening", "url", natsScheme+"://"+c.NatsListener)
+	}
+	if c.NatsWebsocketListener != "" {
+		logger.Info("listening", "url", wsScheme+"://"+c.NatsWebsocketListener)
+	}
+	if c.NatsClusterListener != "" {
+		logger.Info("listening for cluster routes", "cluster", c.NatsClusterName, "address", c.NatsClusterListener, "routes", len(c.NatsClusterRoutes))
+	}
+	if c.NatsLeafnodeListener != "" {
+		logger.Info("listening for leafnodes", "address", c.NatsLeafnodeListener)
+	}
+	if len(c.NatsLeafnodeRemotes) != 0 {
+		logger.Info("connecting to leafnode remotes", "remotes", len(c.NatsLeafnodeRemotes))
 	}
 
 	// Wait for exit signal.
 	<-sigchan
 
 	logger.Info("received exit signal; exiting cleanly")
+	shuttingDown.Store(true)
 
 	// Allow forced exit if cleanup locks up.
 	go func() {
 		<-sigchan
 		logger.Info("received follow-up exit signal; forcing exit")
-		os.Exit(1)
+		memguard.SafeExit(1)
 	}()
 
 	//
 	// Cleanup.
 	//
 
-	ns.Shutdown()
+	steps := []shutdownStep{
+		{"stop accepting bridged connections", b.Close},
+		{"close Yggdrasil netstack", s.Close},
+		{"stop answering peer requests", pa.Close},
+		{"stop answering stats requests", sa.Close},
+		{"close Yggdrasil node", n.Close},
+	}
+	if a != nil {
+		steps = append(steps, shutdownStep{"close admin listener", a.Close})
+	}
+	if h != nil {
+		steps = append(steps, shutdownStep{"stop answering history queries", h.Close})
+	}
+	if wh != nil {
+		steps = append(steps, shutdownStep{"stop forwarding to webhooks", wh.Close})
+	}
+	steps = append(steps, shutdownStep{"drain internal NATS connection", func() error {
+		err := nc.Drain()
+		<-ncClosed
+		return err
+	}})
+	steps = append(steps, shutdownStep{"shut down NATS server", func() error {
+		ns.Shutdown()
+		ns.WaitForShutdown()
+		return nil
+	}})
+	if err := shutdown(logger, c.ShutdownTimeout, steps...); err != nil {
+		logger.Error("shutdown failed", "err", err)
+		memguard.SafeExit(1)
+	}
+
+	logger.Info("shutdown complete")
 }
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"net/http"
+
+	"dev.l1qu1d.net/wraith-labs/wraith_module_comosum/radio"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"gvisor.dev/gvisor/pkg/tcpip"
+)
+
+const METRICS_NAMESPACE = PRODUCT_NAME
+
+// Build a handler exposing Prometheus metrics about the given components.
+func metricsHandler(b *bridge, n *radio.Node, s *radio.YggdrasilNetstack) http.Handler {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	//
+	// Bridge.
+	//
+
+	bridgeTotals := func(field func(bridgePeerStats) uint64) func() float64 {
+		return func() float64 { return float64(field(b.Totals())) }
+	}
+	registry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: METRICS_NAMESPACE,
+			Subsystem: "bridge",
+			Name:      "active_connections",
+			Help:      "Number of NATS connections currently bridged over Yggdrasil.",
+		}, func() float64 { return float64(b.Active()) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: METRICS_NAMESPACE,
+			Subsystem: "bridge",
+			Name:      "peers",
+			Help:      "Number of distinct Yggdrasil addresses with bridged connections open.",
+		}, func() float64 { return float64(len(b.Stats())) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: METRICS_NAMESPACE,
+			Subsystem: "bridge",
+			Name:      "accepted_connections_total",
+			Help:      "Number of NATS connections accepted by the bridge.",
+		}, bridgeTotals(func(s bridgePeerStats) uint64 { return s.Total })),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: METRICS_NAMESPACE,
+			Subsystem: "bridge",
+			Name:      "rejected_connections_total",
+			Help:      "Number of NATS connections rejected by the bridge due to connection limits.",
+		}, bridgeTotals(func(s bridgePeerStats) uint64 { return s.Rejected })),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: METRICS_NAMESPACE,
+			Subsystem: "bridge",
+			Name:      "received_bytes_total",
+			Help:      "Bytes received from Yggdrasil peers over bridged connections.",
+		}, bridgeTotals(func(s bridgePeerStats) uint64 { return s.BytesIn })),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: METRICS_NAMESPACE,
+			Subsystem: "bridge",
+			Name:      "sent_bytes_total",
+			Help:      "Bytes sent to Yggdrasil peers over bridged connections.",
+		}, bridgeTotals(func(s bridgePeerStats) uint64 { return s.BytesOut })),
+	)
+
+	//
+	// Yggdrasil.
+	//
+
+	registry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: METRICS_NAMESPACE,
+			Subsystem: "yggdrasil",
+			Name:      "peers",
+			Help:      "Number of connected Yggdrasil peers.",
+		}, func() float64 {
+			up := 0
+			for _, peer := range n.Peers() {
+				if peer.Up {
+					up++
+				}
+			}
+			return float64(up)
+		}),
+		newNodeStatsCollector(n),
+	)
+
+	//
+	// Netstack.
+	//
+
+	tcpStat := func(name, help string, counter func(tcpip.TCPStats) *tcpip.StatCounter) prometheus.Collector {
+		return prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: METRICS_NAMESPACE,
+			Subsystem: "netstack_tcp",
+			Name:      name,
+			Help:      help,
+		}, func() float64 { return float64(counter(s.TCPStats()).Value()) })
+	}
+	registry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: METRICS_NAMESPACE,
+			Subsystem: "netstack_tcp",
+			Name:      "established_connections",
+			Help:      "Number of TCP connections in the ESTABLISHED or CLOSE-WAIT state.",
+		}, func() float64 { return float64(s.TCPStats().CurrentEstablished.Value()) }),
+		tcpStat("active_openings_total", "Number of outgoing TCP connections opened.",
+			func(t tcpip.TCPStats) *tcpip.StatCounter { return t.ActiveConnectionOpenings }),
+		tcpStat("passive_openings_total", "Number of incoming TCP connections accepted.",
+			func(t tcpip.TCPStats) *tcpip.StatCounter { return t.PassiveConnectionOpenings }),
+		tcpStat("failed_connection_attempts_total", "Number of failed TCP connection attempts.",
+			func(t tcpip.TCPStats) *tcpip.StatCounter { return t.FailedConnectionAttempts }),
+		tcpStat("established_resets_total", "Number of established TCP connections reset.",
+			func(t tcpip.TCPStats) *tcpip.StatCounter { return t.EstablishedResets }),
+		tcpStat("established_timeouts_total", "Number of established TCP connections closed due to timeouts.",
+			func(t tcpip.TCPStats) *tcpip.StatCounter { return t.EstablishedTimedout }),
+		tcpStat("segments_received_total", "Number of valid TCP segments received.",
+			func(t tcpip.TCPStats) *tcpip.StatCounter { return t.ValidSegmentsReceived }),
+		tcpStat("segments_sent_total", "Number of TCP segments sent.",
+			func(t tcpip.TCPStats) *tcpip.StatCounter { return t.SegmentsSent }),
+		tcpStat("retransmits_total", "Number of TCP segments retransmitted.",
+			func(t tcpip.TCPStats) *tcpip.StatCounter { return t.Retransmits }),
+	)
+
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
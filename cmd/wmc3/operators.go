@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"dev.l1qu1d.net/wraith-labs/wraith_module_comosum/cmd/internal/keyfile"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nkeys"
+)
+
+// An operator allowed to connect to the NATS server in addition to the admin
+// user. Each operator authenticates using either a password or an nkey and
+// can be restricted to a subset of subjects.
+type operator struct {
+	// A unique name for this operator. Used as the NATS username for password
+	// authentication and to identify the operator in logs.
+	Name string `json:"name"`
+
+	// The password this operator authenticates with. Mutually exclusive with
+	// Nkey.
+	Password string `json:"password,omitempty"`
+
+	// The public nkey (starting with "U") this operator authenticates with.
+	// Mutually exclusive with Password.
+	Nkey string `json:"nkey,omitempty"`
+
+	// Subject-level permissions for this operator. If left out, the operator
+	// has the same unrestricted access as the admin user. Uses the same format
+	// as the NATS server configuration, for example:
+	//
+	//	{
+	//		"publish": {"allow": ["_INBOX.>"]},
+	//		"subscribe": {"allow": [">"]}
+	//	}
+	Permissions *server.Permissions `json:"permissions,omitempty"`
+}
+
+// Read and validate the list of operators from the JSON file at the given path.
+// The file holds passwords, so it must only be accessible by its owner.
+func loadOperators(path string) ([]operator, error) {
+	data, err := keyfile.ReadSecretFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	operators := []operator{}
+	if err := json.Unmarshal(data, &operators); err != nil {
+		return nil, err
+	}
+
+	seen := map[string]struct{}{}
+	for _, op := range operators {
+		if op.Name == "" {
+			return nil, errors.New("operator with no name")
+		}
+		if _, ok := seen[op.Name]; ok {
+			return nil, fmt.Errorf("duplicate operator %s", op.Name)
+		}
+		seen[op.Name] = struct{}{}
+
+		switch {
+		case op.Password != "" && op.Nkey != "":
+			return nil, fmt.Errorf("operator %s has both a password and an nkey", op.Name)
+		case op.Password == "" && op.Nkey == "":
+			return nil, fmt.Errorf("operator %s has neither a password nor an nkey", op.Name)
+		case op.Nkey != "" && !nkeys.IsValidPublicUserKey(op.Nkey):
+			return nil, fmt.Errorf("operator %s has an invalid user nkey", op.Name)
+		}
+	}
+
+	return operators, nil
+}
+
+// Add the given operators to the NATS server options as members of the given
+// account.
+func applyOperators(opts *server.Options, account *server.Account, operators []operator) {
+	for _, op := range operators {
+		if op.Nkey != "" {
+			opts.Nkeys = append(opts.Nkeys, &server.NkeyUser{
+				Nkey:        op.Nkey,
+				Permissions: op.Permissions,
+				Account:     account,
+			})
+		} else {
+			opts.Users = append(opts.Users, &server.User{
+				Username:    op.Name,
+				Password:    op.Password,
+				Permissions: op.Permissions,
+				Account:     account,
+			})
+		}
+	}
+}
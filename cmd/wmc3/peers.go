@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"dev.l1qu1d.net/wraith-labs/wraith_module_comosum/cmd/internal/wire"
+	"dev.l1qu1d.net/wraith-labs/wraith_module_comosum/radio"
+	"github.com/nats-io/nats.go"
+)
+
+// Lets operators inspect and change the Yggdrasil peers of this instance
+// over NATS.
+type peerAPI struct {
+	node     *radio.Node
+	instance string
+	logger   *slog.Logger
+	subs     []*nats.Subscription
+}
+
+// Start answering peer requests, both on the shared subject and on the one
+// specific to this instance.
+func startPeerAPI(nc *nats.Conn, node *radio.Node, instance string, logger *slog.Logger) (*peerAPI, error) {
+	p := &peerAPI{
+		node:     node,
+		instance: instance,
+		logger:   logger,
+	}
+
+	var err error
+	p.subs, err = subscribeInstance(nc, wire.PEERS_SUBJECT, instance, p.handle)
+	if err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// Stop answering peer requests.
+func (p *peerAPI) Close() error {
+	return unsubscribeAll(p.subs)
+}
+
+// Subscribe to requests for any one instance on the given subject, and to
+// requests for this instance on subject.<instance>.
+func subscribeInstance(nc *nats.Conn, subject, instance string, handler nats.MsgHandler) ([]*nats.Subscription, error) {
+	// Use a queue group so only one instance answers when clustered.
+	shared, err := nc.QueueSubscribe(subject, PRODUCT_NAME, handler)
+	if err != nil {
+		return nil, err
+	}
+	own, err := nc.Subscribe(subject+"."+instance, handler)
+	if err != nil {
+		_ = shared.Unsubscribe()
+		return nil, err
+	}
+
+	return []*nats.Subscription{shared, own}, nil
+}
+
+func unsubscribeAll(subs []*nats.Subscription) error {
+	errs := []error{}
+	for _, sub := range subs {
+		errs = append(errs, sub.Unsubscribe())
+	}
+	return errors.Join(errs...)
+}
+
+func (p *peerAPI) handle(msg *nats.Msg) {
+	req := wire.PeersRequest{}
+	var err error
+	if err = json.Unmarshal(msg.Data, &req); err != nil {
+		err = fmt.Errorf("malformed request: %w", err)
+	} else {
+		switch req.Op {
+		case "", wire.PEERS_OP_LIST:
+		case wire.PEERS_OP_ADD:
+			errs := []error{}
+			for _, uri := range req.URIs {
+				errs = append(errs, p.node.AddPeer(uri))
+			}
+			err = errors.Join(errs...)
+		case wire.PEERS_OP_REMOVE:
+			errs := []error{}
+			for _, uri := range req.URIs {
+				errs = append(errs, p.node.RemovePeer(uri))
+			}
+			err = errors.Join(errs...)
+		case wire.PEERS_OP_SET:
+			if len(req.URIs) == 0 && !req.Clear {
+				err = errors.New("refusing to remove all peers without clear")
+				break
+			}
+			err = p.node.SetPeers(req.URIs)
+		case wire.PEERS_OP_FILTER:
+			if req.Filter == nil {
+				err = errors.New("no filter given")
+				break
+			}
+			err = p.node.SetPeerFilter(*req.Filter)
+		default:
+			err = fmt.Errorf("unknown op %q", req.Op)
+		}
+		if req.Op != "" && req.Op != wire.PEERS_OP_LIST {
+			p.logger.Info("peer change requested over NATS", "op", req.Op, "uris", req.URIs, "filter", req.Filter)
+		}
+	}
+
+	result := wire.PeersResult{
+		Instance:   p.instance,
+		Configured: p.node.ConfiguredPeers(),
+		Peers:      radio.NewPeerStatuses(p.node.Peers()),
+		Filter:     p.node.PeerFilter(),
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		p.logger.Error("failed to marshal peers result", "err", err)
+		return
+	}
+	if err := msg.Respond(data); err != nil {
+		p.logger.Warn("failed to respond to peers request", "err", err)
+	}
+}
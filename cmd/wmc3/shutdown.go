@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// A single named step of the shutdown sequence.
+type shutdownStep struct {
+	name string
+	fn   func() error
+}
+
+// Run the given steps in order, giving up if they don't all complete within
+// the timeout. Errors from individual steps are logged but do not stop the
+// sequence. Returns an error only if the deadline was exceeded.
+func shutdown(logger *slog.Logger, timeout time.Duration, steps ...shutdownStep) error {
+	var current atomic.Value
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		for _, step := range steps {
+			current.Store(step.name)
+			logger.Debug("shutdown: " + step.name)
+			if err := step.fn(); err != nil {
+				logger.Warn("shutdown: "+step.name+" failed", "err", err)
+			}
+		}
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-time.After(timeout):
+		step, _ := current.Load().(string)
+		return errors.New(fmt.Sprintf("shutdown did not complete within %s (stuck at: %s)", timeout, step))
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+
+	"dev.l1qu1d.net/wraith-labs/wraith_module_comosum/cmd/internal/wire"
+	"dev.l1qu1d.net/wraith-labs/wraith_module_comosum/radio"
+	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Answers requests for statistics about this instance's Yggdrasil node.
+type statsAPI struct {
+	node     *radio.Node
+	instance string
+	logger   *slog.Logger
+	subs     []*nats.Subscription
+}
+
+// Start answering stats requests, both on the shared subject and on the one
+// specific to this instance.
+func startStatsAPI(nc *nats.Conn, node *radio.Node, instance string, logger *slog.Logger) (*statsAPI, error) {
+	a := &statsAPI{
+		node:     node,
+		instance: instance,
+		logger:   logger,
+	}
+
+	var err error
+	a.subs, err = subscribeInstance(nc, wire.STATS_SUBJECT, instance, a.handle)
+	if err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// Stop answering stats requests.
+func (a *statsAPI) Close() error {
+	return unsubscribeAll(a.subs)
+}
+
+func (a *statsAPI) handle(msg *nats.Msg) {
+	data, err := json.Marshal(wire.StatsResult{
+		Instance: a.instance,
+		Stats:    a.node.Stats(),
+	})
+	if err != nil {
+		a.logger.Error("failed to marshal stats result", "err", err)
+		return
+	}
+	if err := msg.Respond(data); err != nil {
+		a.logger.Warn("failed to respond to stats request", "err", err)
+	}
+}
+
+// Exposes a node's statistics as Prometheus metrics, taking one snapshot per
+// scrape. Only outbound peers get metrics of their own, since anyone can
+// connect inbound and each would add a new label value.
+type nodeStatsCollector struct {
+	node *radio.Node
+
+	routingEntries *prometheus.Desc
+	sessions       *prometheus.Desc
+	paths          *prometheus.Desc
+	treeEntries    *prometheus.Desc
+	inboundPeers   *prometheus.Desc
+	peerUp         *prometheus.Desc
+	peerRXBytes    *prometheus.Desc
+	peerTXBytes    *prometheus.Desc
+	sessionRXBytes *prometheus.Desc
+	sessionTXBytes *prometheus.Desc
+}
+
+func newNodeStatsCollector(n *radio.Node) *nodeStatsCollector {
+	desc := func(name, help string, labels ...string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(METRICS_NAMESPACE, "yggdrasil", name), help, labels, nil)
+	}
+	return &nodeStatsCollector{
+		node:           n,
+		routingEntries: desc("routing_entries", "Number of entries in the Yggdrasil routing table."),
+		sessions:       desc("sessions", "Number of open Yggdrasil sessions."),
+		paths:          desc("paths", "Number of known paths to other Yggdrasil nodes."),
+		treeEntries:    desc("tree_entries", "Number of known nodes in the Yggdrasil spanning tree."),
+		inboundPeers:   desc("inbound_peers", "Number of connected inbound Yggdrasil peers."),
+		peerUp:         desc("peer_up", "Whether an outbound Yggdrasil peering is up.", "uri"),
+		peerRXBytes:    desc("peer_received_bytes_total", "Bytes received from an outbound Yggdrasil peer on its current connection.", "uri"),
+		peerTXBytes:    desc("peer_sent_bytes_total", "Bytes sent to an outbound Yggdrasil peer on its current connection.", "uri"),
+		sessionRXBytes: desc("session_received_bytes_total", "Bytes received over all open Yggdrasil sessions."),
+		sessionTXBytes: desc("session_sent_bytes_total", "Bytes sent over all open Yggdrasil sessions."),
+	}
+}
+
+func (c *nodeStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(c, ch)
+}
+
+func (c *nodeStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.node.Stats()
+
+	ch <- prometheus.MustNewConstMetric(c.routingEntries, prometheus.GaugeValue, float64(stats.Self.RoutingEntries))
+	ch <- prometheus.MustNewConstMetric(c.sessions, prometheus.GaugeValue, float64(len(stats.Sessions)))
+	ch <- prometheus.MustNewConstMetric(c.paths, prometheus.GaugeValue, float64(stats.Paths))
+	ch <- prometheus.MustNewConstMetric(c.treeEntries, prometheus.GaugeValue, float64(stats.Tree.Entries))
+
+	inbound := 0
+	seen := map[string]struct{}{}
+	for _, peer := range stats.Peers {
+		if peer.Inbound {
+			if peer.Up {
+				inbound++
+			}
+			continue
+		}
+		// Each label set may only be reported once per scrape.
+		if _, ok := seen[peer.URI]; ok {
+			continue
+		}
+		seen[peer.URI] = struct{}{}
+
+		up := 0.0
+		if peer.Up {
+			up = 1
+		}
+		ch <- prometheus.MustNewConstMetric(c.peerUp, prometheus.GaugeValue, up, peer.URI)
+		ch <- prometheus.MustNewConstMetric(c.peerRXBytes, prometheus.CounterValue, float64(peer.RXBytes), peer.URI)
+		ch <- prometheus.MustNewConstMetric(c.peerTXBytes, prometheus.CounterValue, float64(peer.TXBytes), peer.URI)
+	}
+	ch <- prometheus.MustNewConstMetric(c.inboundPeers, prometheus.GaugeValue, float64(inbound))
+
+	var rx, tx uint64
+	for _, session := range stats.Sessions {
+		rx += session.RXBytes
+		tx += session.TXBytes
+	}
+	ch <- prometheus.MustNewConstMetric(c.sessionRXBytes, prometheus.CounterValue, float64(rx))
+	ch <- prometheus.MustNewConstMetric(c.sessionTXBytes, prometheus.CounterValue, float64(tx))
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+)
+
+// Build a TLS config for the external NATS listeners based on the given
+// configuration. Returns nil if TLS is not configured.
+func makeTLSConfig(c conf) (*tls.Config, error) {
+	if c.NatsTLSSelfSigned {
+		cert, err := generateSelfSignedCert(c.NatsListener, c.NatsWebsocketListener)
+		if err != nil {
+			return nil, err
+		}
+		return &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		}, nil
+	}
+
+	if c.NatsTLSCert == "" {
+		return nil, nil
+	}
+
+	return server.GenTLSConfig(&server.TLSConfigOpts{
+		CertFile: c.NatsTLSCert,
+		KeyFile:  c.NatsTLSKey,
+		CaFile:   c.NatsTLSClientCA,
+		Verify:   c.NatsTLSClientCA != "",
+	})
+}
+
+// Build a TLS config for route or leafnode connections to and from other NATS
+// servers. Both sides must present certificates signed by the given CA, as
+// NATS requires for routes. This server presents the NATS certificate, if one
+// is configured.
+func makePeerTLSConfig(c conf, caFile string) (*tls.Config, error) {
+	ca, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("no certificates found in CA file")
+	}
+
+	config := &tls.Config{
+		RootCAs:    pool,
+		ClientCAs:  pool,
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		MinVersion: tls.VersionTLS12,
+	}
+	if c.NatsTLSCert != "" {
+		cert, err := tls.LoadX509KeyPair(c.NatsTLSCert, c.NatsTLSKey)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+// Generate a throwaway self-signed certificate valid for the hosts of the given
+// listen strings. Only meant for development setups.
+func generateSelfSignedCert(listeners ...string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: PRODUCT_NAME},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	for _, listener := range listeners {
+		host, _, err := net.SplitHostPort(listener)
+		if err != nil || host == "" {
+			continue
+		}
+		if ip := net.ParseIP(host); ip != nil {
+			if !ip.IsUnspecified() {
+				template.IPAddresses = append(template.IPAddresses, ip)
+			}
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+	}, nil
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"dev.l1qu1d.net/wraith-labs/wraith_module_comosum/cmd/internal/keyfile"
+	"dev.l1qu1d.net/wraith-labs/wraith_module_comosum/radio"
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	// The header carrying the HMAC-SHA256 signature of the request body.
+	WEBHOOK_SIGNATURE_HEADER = "X-Comosum-Signature"
+
+	// The header carrying the subject the forwarded message was published on.
+	WEBHOOK_SUBJECT_HEADER = "X-Comosum-Subject"
+
+	// The prefix of NATS subjects on which messages that could not be
+	// delivered to a webhook are published. The full subject is
+	// WEBHOOK_DEADLETTER_SUBJECT_PREFIX.<webhook name>.
+	WEBHOOK_DEADLETTER_SUBJECT_PREFIX = "comosum.webhooks.deadletter"
+
+	// How many messages may wait for delivery to a single sink before new
+	// ones are dead-lettered straight away.
+	WEBHOOK_QUEUE_SIZE = 256
+
+	// How long to wait before retrying a failed delivery for the first time.
+	// The wait doubles with each further retry.
+	WEBHOOK_RETRY_BACKOFF = time.Second
+
+	// The payload sent if a sink does not define a template.
+	WEBHOOK_DEFAULT_TEMPLATE = `{"subject":{{json .Subject}},"time":{{json .Time}},"event":{{.Raw}}}`
+)
+
+// The configuration of a single webhook sink, as read from the webhooks file.
+type webhookConfig struct {
+	// A unique name for the sink, used in logs and dead-letter subjects.
+	Name string `json:"name"`
+
+	// The URL to POST payloads to.
+	URL string `json:"url"`
+
+	// The NATS subjects (wildcards allowed) whose messages are forwarded.
+	// Defaults to all client events.
+	Subjects []string `json:"subjects,omitempty"`
+
+	// A text/template producing the JSON request body. See webhookPayload for
+	// the available fields. The "json" function encodes a value as JSON.
+	Template string `json:"template,omitempty"`
+
+	// If set, requests are signed with HMAC-SHA256 using this secret.
+	Secret string `json:"secret,omitempty"`
+
+	// Extra HTTP headers to send with each request.
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// How many times to retry a failed delivery before dead-lettering it. Must
+	// not be negative.
+	MaxRetries int `json:"max_retries,omitempty"`
+
+	// How long to wait for the sink to respond to a single request, as a Go
+	// duration string. Defaults to 10s.
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// The data available to webhook templates.
+type webhookPayload struct {
+	// The subject the message was published on.
+	Subject string
+
+	// When wmc3 started delivering the message.
+	Time time.Time
+
+	// The message decoded as a client event. Zero if it isn't one.
+	Event radio.Event
+
+	// The raw message data if it is valid JSON, otherwise the data encoded as
+	// a JSON string. Either way, it can be embedded in a JSON template as-is.
+	Raw string
+}
+
+// A message which could not be delivered to a sink, as published on the
+// dead-letter subject.
+type webhookDeadLetter struct {
+	Sink    string          `json:"sink"`
+	Subject string          `json:"subject"`
+	Time    time.Time       `json:"time"`
+	Error   string          `json:"error"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// Read and validate webhook sink configurations from the JSON file at the
+// given path. The file holds signing secrets, so it must only be accessible by
+// its owner.
+func loadWebhooks(path string) ([]webhookConfig, error) {
+	data, err := keyfile.ReadSecretFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	configs := []webhookConfig{}
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, err
+	}
+
+	seen := map[string]struct{}{}
+	for i, cfg := range configs {
+		if cfg.Name == "" {
+			return nil, errors.New("webhook with no name")
+		}
+		if !isSubjectToken(cfg.Name) {
+			return nil, fmt.Errorf("webhook name %q is not a valid subject token", cfg.Name)
+		}
+		if _, ok := seen[cfg.Name]; ok {
+			return nil, fmt.Errorf("duplicate webhook %s", cfg.Name)
+		}
+		seen[cfg.Name] = struct{}{}
+
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("webhook %s has no url", cfg.Name)
+		}
+		if u, err := url.Parse(cfg.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("webhook %s has invalid url %q, must be an absolute http or https URL", cfg.Name, cfg.URL)
+		}
+		if cfg.MaxRetries < 0 {
+			return nil, fmt.Errorf("webhook %s has negative max_retries", cfg.Name)
+		}
+		if len(cfg.Subjects) == 0 {
+			configs[i].Subjects = []string{radio.EVENTS_SUBJECT_PREFIX + ".>"}
+		}
+		if cfg.Timeout != "" {
+			if _, err := time.ParseDuration(cfg.Timeout); err != nil {
+				return nil, fmt.Errorf("webhook %s has invalid timeout: %w", cfg.Name, err)
+			}
+		}
+		if _, err := parseWebhookTemplate(cfg); err != nil {
+			return nil, fmt.Errorf("webhook %s has invalid template: %w", cfg.Name, err)
+		}
+	}
+
+	return configs, nil
+}
+
+func parseWebhookTemplate(cfg webhookConfig) (*template.Template, error) {
+	text := cfg.Template
+	if text == "" {
+		text = WEBHOOK_DEFAULT_TEMPLATE
+	}
+
+	return template.New(cfg.Name).Funcs(template.FuncMap{
+		"json": func(v any) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}).Parse(text)
+}
+
+// Forwards NATS messages to a single HTTP endpoint.
+type webhookSink struct {
+	cfg      webhookConfig
+	template *template.Template
+	client   *http.Client
+	nc       *nats.Conn
+	logger   *slog.Logger
+
+	queue   chan *nats.Msg
+	subs    []*nats.Subscription
+	ctx     context.Context
+	backoff time.Duration
+}
+
+// Forwards NATS messages to all configured webhook sinks.
+type webhooks struct {
+	sinks  []*webhookSink
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// Subscribe to the configured subjects of all sinks and start delivering.
+func startWebhooks(nc *nats.Conn, logger *slog.Logger, configs []webhookConfig) (*webhooks, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &webhooks{cancel: cancel}
+
+	for _, cfg := range configs {
+		sink, err := newWebhookSink(ctx, nc, logger, cfg)
+		if err != nil {
+			w.Close()
+			return nil, err
+		}
+		// Added first so Close cleans up its subscriptions if a later one
+		// fails.
+		w.sinks = append(w.sinks, sink)
+		for _, subject := range cfg.Subjects {
+			// Use a queue group so only one instance delivers each message
+			// when clustered.
+			sub, err := nc.QueueSubscribe(subject, PRODUCT_NAME+"-webhook-"+cfg.Name, sink.enqueue)
+			if err != nil {
+				w.Close()
+				return nil, err
+			}
+			sink.subs = append(sink.subs, sub)
+		}
+
+		w.wg.Add(1)
+		go func() {
+			defer w.wg.Done()
+			sink.run()
+		}()
+	}
+
+	return w, nil
+}
+
+func newWebhookSink(ctx context.Context, nc *nats.Conn, logger *slog.Logger, cfg webhookConfig) (*webhookSink, error) {
+	tmpl, err := parseWebhookTemplate(cfg)
+	if err != nil {
+		return nil, err
+	}
+	timeout := 10 * time.Second
+	if cfg.Timeout != "" {
+		timeout, _ = time.ParseDuration(cfg.Timeout)
+	}
+
+	return &webhookSink{
+		cfg:      cfg,
+		template: tmpl,
+		client:   &http.Client{Timeout: timeout},
+		nc:       nc,
+		logger:   logger,
+		queue:    make(chan *nats.Msg, WEBHOOK_QUEUE_SIZE),
+		ctx:      ctx,
+		backoff:  WEBHOOK_RETRY_BACKOFF,
+	}, nil
+}
+
+// Stop forwarding messages. Deliveries in progress and messages still queued
+// are abandoned.
+func (w *webhooks) Close() error {
+	for _, sink := range w.sinks {
+		for _, sub := range sink.subs {
+			_ = sub.Unsubscribe()
+		}
+	}
+	w.cancel()
+	w.wg.Wait()
+
+	for _, sink := range w.sinks {
+		if dropped := len(sink.queue); dropped != 0 {
+			sink.logger.Warn("webhook dropped queued messages on shutdown", "webhook", sink.cfg.Name, "messages", dropped)
+		}
+	}
+
+	return nil
+}
+
+func (s *webhookSink) enqueue(msg *nats.Msg) {
+	// Never forward dead letters, or a failing sink subscribed to them would
+	// keep feeding itself its own.
+	if strings.HasPrefix(msg.Subject, WEBHOOK_DEADLETTER_SUBJECT_PREFIX+".") {
+		return
+	}
+
+	select {
+	case s.queue <- msg:
+	default:
+		s.deadLetter(msg, errors.New("delivery queue full"))
+	}
+}
+
+func (s *webhookSink) run() {
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case msg := <-s.queue:
+			if err := s.deliver(msg); err != nil {
+				s.logger.Warn("webhook giving up on message", "webhook", s.cfg.Name, "subject", msg.Subject, "err", err)
+				s.deadLetter(msg, err)
+			}
+		}
+	}
+}
+
+// Render the payload for a message and send it, retrying with exponential
+// backoff on failure.
+func (s *webhookSink) deliver(msg *nats.Msg) error {
+	payload := webhookPayload{
+		Subject: msg.Subject,
+		Time:    time.Now(),
+		Raw:     string(msg.Data),
+	}
+	_ = json.Unmarshal(msg.Data, &payload.Event)
+	if !json.Valid(msg.Data) {
+		raw, _ := json.Marshal(string(msg.Data))
+		payload.Raw = string(raw)
+	}
+
+	body := bytes.Buffer{}
+	if err := s.template.Execute(&body, payload); err != nil {
+		return fmt.Errorf("rendering template: %w", err)
+	}
+
+	backoff := s.backoff
+	var err error
+	for attempt := 0; attempt <= s.cfg.MaxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-s.ctx.Done():
+				return s.ctx.Err()
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+		if err = s.post(msg.Subject, body.Bytes()); err == nil {
+			return nil
+		}
+		s.logger.Debug("webhook attempt failed", "webhook", s.cfg.Name, "attempt", attempt+1, "err", err)
+	}
+
+	return err
+}
+
+func (s *webhookSink) post(subject string, body []byte) error {
+	req, err := http.NewRequestWithContext(s.ctx, http.MethodPost, s.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", PRODUCT_NAME)
+	req.Header.Set(WEBHOOK_SUBJECT_HEADER, subject)
+	for name, value := range s.cfg.Headers {
+		req.Header.Set(name, value)
+	}
+	if s.cfg.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(s.cfg.Secret))
+		mac.Write(body)
+		req.Header.Set(WEBHOOK_SIGNATURE_HEADER, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return errors.New("unexpected status " + strconv.Itoa(res.StatusCode))
+	}
+
+	return nil
+}
+
+// Publish an undeliverable message on the sink's dead-letter subject.
+func (s *webhookSink) deadLetter(msg *nats.Msg, reason error) {
+	data := json.RawMessage(msg.Data)
+	if !json.Valid(msg.Data) {
+		data, _ = json.Marshal(string(msg.Data))
+	}
+	dl, err := json.Marshal(webhookDeadLetter{
+		Sink:    s.cfg.Name,
+		Subject: msg.Subject,
+		Time:    time.Now(),
+		Error:   reason.Error(),
+		Data:    data,
+	})
+	if err != nil {
+		s.logger.Error("webhook failed to marshal dead letter", "webhook", s.cfg.Name, "err", err)
+		return
+	}
+	if err := s.nc.Publish(WEBHOOK_DEADLETTER_SUBJECT_PREFIX+"."+s.cfg.Name, dl); err != nil {
+		s.logger.Error("webhook failed to publish dead letter", "webhook", s.cfg.Name, "err", err)
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"dev.l1qu1d.net/wraith-labs/wraith_module_comosum/radio"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+)
+
+// A request received by a webhookEndpoint.
+type webhookRequest struct {
+	header http.Header
+	body   []byte
+}
+
+// An HTTP endpoint recording the requests it gets and answering them with the
+// status returned by respond.
+type webhookEndpoint struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []webhookRequest
+}
+
+func newWebhookEndpoint(t *testing.T, respond func(attempt int) int) *webhookEndpoint {
+	t.Helper()
+
+	e := &webhookEndpoint{}
+	e.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		e.mu.Lock()
+		e.requests = append(e.requests, webhookRequest{r.Header.Clone(), body})
+		attempt := len(e.requests)
+		e.mu.Unlock()
+		w.WriteHeader(respond(attempt))
+	}))
+	t.Cleanup(e.Close)
+	return e
+}
+
+func (e *webhookEndpoint) Requests() []webhookRequest {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return append([]webhookRequest(nil), e.requests...)
+}
+
+func status(code int) func(int) int {
+	return func(int) int { return code }
+}
+
+// Start an embedded NATS server and connect to it.
+func startNATS(t *testing.T) *nats.Conn {
+	t.Helper()
+
+	ns, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1, NoLog: true, NoSigs: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go ns.Start()
+	t.Cleanup(ns.Shutdown)
+	if !ns.ReadyForConnections(5 * time.Second) {
+		t.Fatal("NATS server did not start")
+	}
+
+	nc, err := nats.Connect(ns.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(nc.Close)
+	return nc
+}
+
+func newTestSink(t *testing.T, cfg webhookConfig) *webhookSink {
+	t.Helper()
+
+	sink, err := newWebhookSink(context.Background(), nil, radio.DiscardLogger(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.backoff = time.Millisecond
+	return sink
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	e := newWebhookEndpoint(t, status(http.StatusNoContent))
+	sink := newTestSink(t, webhookConfig{
+		Name:    "test",
+		URL:     e.URL,
+		Headers: map[string]string{"Authorization": "Bearer token"},
+	})
+
+	err := sink.deliver(&nats.Msg{Subject: "comosum.events.a.b", Data: []byte(`{"client":"a","op":"b"}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	requests := e.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("endpoint got %d requests, want 1", len(requests))
+	}
+	req := requests[0]
+	if got := req.header.Get(WEBHOOK_SUBJECT_HEADER); got != "comosum.events.a.b" {
+		t.Errorf("subject header is %q", got)
+	}
+	if got := req.header.Get("Authorization"); got != "Bearer token" {
+		t.Errorf("extra header is %q", got)
+	}
+	if got := req.header.Get(WEBHOOK_SIGNATURE_HEADER); got != "" {
+		t.Errorf("unsigned request has signature %q", got)
+	}
+
+	payload := struct {
+		Subject string         `json:"subject"`
+		Time    time.Time      `json:"time"`
+		Event   map[string]any `json:"event"`
+	}{}
+	if err := json.Unmarshal(req.body, &payload); err != nil {
+		t.Fatalf("body %s is not valid JSON: %v", req.body, err)
+	}
+	if payload.Subject != "comosum.events.a.b" || payload.Event["client"] != "a" || payload.Time.IsZero() {
+		t.Errorf("unexpected payload %s", req.body)
+	}
+}
+
+func TestWebhookSigning(t *testing.T) {
+	e := newWebhookEndpoint(t, status(http.StatusOK))
+	sink := newTestSink(t, webhookConfig{Name: "test", URL: e.URL, Secret: "secret"})
+
+	if err := sink.deliver(&nats.Msg{Subject: "x", Data: []byte("not json")}); err != nil {
+		t.Fatal(err)
+	}
+
+	req := e.Requests()[0]
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write(req.body)
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if got := req.header.Get(WEBHOOK_SIGNATURE_HEADER); got != want {
+		t.Errorf("signature is %q, want %q", got, want)
+	}
+}
+
+func TestWebhookRetry(t *testing.T) {
+	// Fail twice, then succeed.
+	e := newWebhookEndpoint(t, func(attempt int) int {
+		if attempt <= 2 {
+			return http.StatusInternalServerError
+		}
+		return http.StatusOK
+	})
+	sink := newTestSink(t, webhookConfig{Name: "test", URL: e.URL, MaxRetries: 2})
+	sink.backoff = 20 * time.Millisecond
+
+	start := time.Now()
+	if err := sink.deliver(&nats.Msg{Subject: "x", Data: []byte("{}")}); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(e.Requests()); n != 3 {
+		t.Errorf("endpoint got %d requests, want 3", n)
+	}
+	// The backoff doubles: 20ms, then 40ms.
+	if elapsed := time.Since(start); elapsed < 60*time.Millisecond {
+		t.Errorf("retries took %v, want at least 60ms of backoff", elapsed)
+	}
+
+	// One retry is not enough this time.
+	e = newWebhookEndpoint(t, status(http.StatusBadGateway))
+	sink = newTestSink(t, webhookConfig{Name: "test", URL: e.URL, MaxRetries: 1})
+	if err := sink.deliver(&nats.Msg{Subject: "x", Data: []byte("{}")}); err == nil {
+		t.Error("delivery to a failing endpoint succeeded")
+	}
+	if n := len(e.Requests()); n != 2 {
+		t.Errorf("endpoint got %d requests, want 2", n)
+	}
+}
+
+func TestWebhookDeadLetter(t *testing.T) {
+	nc := startNATS(t)
+	e := newWebhookEndpoint(t, func(attempt int) int {
+		if attempt == 1 {
+			return http.StatusInternalServerError
+		}
+		return http.StatusOK
+	})
+
+	deadLetters, err := nc.SubscribeSync(WEBHOOK_DEADLETTER_SUBJECT_PREFIX + ".test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The sink sees everything, including its own dead letters.
+	w, err := startWebhooks(nc, radio.DiscardLogger(), []webhookConfig{{Name: "test", URL: e.URL, Subjects: []string{">"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	if err := nc.Publish("test.first", []byte(`{"n":1}`)); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := deadLetters.NextMsg(2 * time.Second)
+	if err != nil {
+		t.Fatalf("no dead letter: %v", err)
+	}
+	dl := webhookDeadLetter{}
+	if err := json.Unmarshal(msg.Data, &dl); err != nil {
+		t.Fatal(err)
+	}
+	if dl.Sink != "test" || dl.Subject != "test.first" || dl.Error == "" || string(dl.Data) != `{"n":1}` {
+		t.Errorf("unexpected dead letter %s", msg.Data)
+	}
+
+	// Messages are delivered in order, so if the dead letter had been queued
+	// it would reach the endpoint before this one.
+	if err := nc.Publish("test.second", []byte(`{"n":2}`)); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the second message", func() bool { return len(e.Requests()) >= 2 })
+	requests := e.Requests()
+	if len(requests) != 2 {
+		t.Fatalf("endpoint got %d requests, want 2", len(requests))
+	}
+	if got := requests[1].header.Get(WEBHOOK_SUBJECT_HEADER); got != "test.second" {
+		t.Errorf("second request was for %q, want test.second", got)
+	}
+}
+
+func TestLoadWebhooks(t *testing.T) {
+	write := func(t *testing.T, data string, mode os.FileMode) string {
+		t.Helper()
+
+		path := filepath.Join(t.TempDir(), "webhooks.json")
+		if err := os.WriteFile(path, []byte(data), mode); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	configs, err := loadWebhooks(write(t, `[{"name":"ok","url":"https://example.com/hook","max_retries":3}]`, 0o600))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(configs) != 1 || configs[0].Subjects[0] != radio.EVENTS_SUBJECT_PREFIX+".>" {
+		t.Errorf("unexpected configs %+v", configs)
+	}
+
+	if _, err := loadWebhooks(write(t, `[{"name":"ok","url":"https://example.com/hook"}]`, 0o644)); err == nil {
+		t.Error("a webhooks file readable by others was accepted")
+	}
+
+	for name, data := range map[string]string{
+		"negative retries": `[{"name":"a","url":"https://example.com","max_retries":-1}]`,
+		"relative url":     `[{"name":"a","url":"/hook"}]`,
+		"no host":          `[{"name":"a","url":"http:///hook"}]`,
+		"other scheme":     `[{"name":"a","url":"ftp://example.com/hook"}]`,
+		"unparsable url":   `[{"name":"a","url":"http://exa mple.com/%zz"}]`,
+	} {
+		if _, err := loadWebhooks(write(t, data, 0o600)); err == nil {
+			t.Errorf("%s was accepted", name)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"dev.l1qu1d.net/wraith-labs/wraith_module_comosum/cmd/internal/wire"
+	"github.com/nats-io/nats.go"
+)
+
+func runHistory(args []string, connect func() (*nats.Conn, error)) error {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	client := fs.String("client", "", "only show events for this client public key (hex)")
+	op := fs.String("op", "", "only show events of this type (heartbeat, exchange, response, error)")
+	key := fs.String("key", "", "only show events involving this SHM key")
+	since := fs.String("since", "", "only show events after this time (RFC 3339) or this long ago (duration)")
+	until := fs.String("until", "", "only show events before this time (RFC 3339) or this long ago (duration)")
+	limit := fs.Int("limit", 0, "maximum number of events to show (server default if 0)")
+	fs.Parse(args)
+
+	query := wire.HistoryQuery{
+		Client: *client,
+		Op:     *op,
+		Key:    *key,
+		Limit:  *limit,
+	}
+	var err error
+	if query.Since, err = parseTime(*since); err != nil {
+		return errors.Join(errors.New("invalid -since"), err)
+	}
+	if query.Until, err = parseTime(*until); err != nil {
+		return errors.Join(errors.New("invalid -until"), err)
+	}
+
+	nc, err := connect()
+	if err != nil {
+		return err
+	}
+	defer nc.Close()
+
+	result := wire.HistoryResult{}
+	if err := request(nc, wire.HISTORY_QUERY_SUBJECT, query, &result); err != nil {
+		return err
+	}
+	if result.Error != "" {
+		return errors.New(result.Error)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	for _, event := range result.Events {
+		if err := enc.Encode(event); err != nil {
+			return err
+		}
+	}
+	if result.Truncated {
+		fmt.Fprintf(os.Stderr, "more than %d events matched; narrow down the query or raise -limit\n", len(result.Events))
+	}
+
+	return nil
+}
+
+// Parse a point in time given either as an RFC 3339 timestamp or as a duration
+// before now. Returns the zero time for an empty string.
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"dev.l1qu1d.net/wraith-labs/wraith_module_comosum/cmd/internal/keyfile"
+	"dev.l1qu1d.net/wraith-labs/wraith_module_comosum/radio"
+	"github.com/awnumar/memguard"
+	"github.com/nats-io/nats.go"
+)
+
+func runKeygen(args []string, _ func() (*nats.Conn, error)) error {
+	fs := flag.NewFlagSet("keygen", flag.ExitOnError)
+	out := fs.String("out", "", "path to write the key file to (required, must not exist)")
+	in := fs.String("in", "", "re-encode this existing key file instead of generating a new key")
+	passphraseFile := fs.String("passphrase-file", "", "encrypt the key with the passphrase on the first line of this file (- for stdin)")
+	fs.Parse(args)
+	defer memguard.Purge()
+
+	if *out == "" {
+		return errors.New("-out is required")
+	}
+	if *in == keyfile.KEY_FILE_STDIN && *passphraseFile == keyfile.KEY_FILE_STDIN {
+		return errors.New("-in and -passphrase-file cannot both be read from stdin")
+	}
+	passphrase := keyfile.PassphraseFile(*passphraseFile)
+
+	var key *memguard.LockedBuffer
+	if *in != "" {
+		enclave, err := keyfile.LoadKeyFile(*in, passphrase)
+		if err != nil {
+			return errors.Join(errors.New("failed to load key"), err)
+		}
+		if key, err = enclave.Open(); err != nil {
+			return err
+		}
+	} else {
+		_, priv, err := ed25519.GenerateKey(nil)
+		if err != nil {
+			return err
+		}
+		key = memguard.NewBufferFromBytes(priv)
+	}
+	defer key.Destroy()
+	priv := ed25519.PrivateKey(key.Bytes())
+
+	var data []byte
+	if passphrase == nil {
+		data = make([]byte, hex.EncodedLen(len(priv))+1)
+		hex.Encode(data, priv)
+		data[len(data)-1] = '\n'
+	} else {
+		p, err := passphrase()
+		if err != nil {
+			return errors.Join(errors.New("failed to get passphrase"), err)
+		}
+		defer p.Destroy()
+		if data, err = keyfile.EncryptKey(priv, p.Bytes()); err != nil {
+			return err
+		}
+	}
+	defer memguard.WipeBytes(data)
+
+	f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	fmt.Printf("%x\n", priv.Public())
+	return nil
+}
+
+func runAuthorize(args []string, _ func() (*nats.Conn, error)) error {
+	fs := flag.NewFlagSet("authorize", flag.ExitOnError)
+	keyFile := fs.String("key", "", "path to the admin key file (required, - for stdin)")
+	passphraseFile := fs.String("passphrase-file", "", "read the admin key passphrase from the first line of this file (- for stdin)")
+	node := fs.String("node", "", "public key (hex) of the C2 node to authorize (required)")
+	fs.Parse(args)
+	defer memguard.Purge()
+
+	if *keyFile == "" || *node == "" {
+		return errors.New("-key and -node are required")
+	}
+	if *keyFile == keyfile.KEY_FILE_STDIN && *passphraseFile == keyfile.KEY_FILE_STDIN {
+		return errors.New("-key and -passphrase-file cannot both be read from stdin")
+	}
+	nodeKey, err := hex.DecodeString(*node)
+	if err != nil || len(nodeKey) != ed25519.PublicKeySize {
+		return errors.New("-node is not a hex-encoded public key")
+	}
+
+	enclave, err := keyfile.LoadKeyFile(*keyFile, keyfile.PassphraseFile(*passphraseFile))
+	if err != nil {
+		return errors.Join(errors.New("failed to load admin key"), err)
+	}
+	key, err := enclave.Open()
+	if err != nil {
+		return err
+	}
+	defer key.Destroy()
+
+	// Go's crypto code can't sign with keys outside the Go heap, so sign with
+	// a short-lived copy.
+	priv := ed25519.PrivateKey(bytes.Clone(key.Bytes()))
+	defer memguard.WipeBytes(priv)
+	authorization, err := radio.AuthorizeNode(priv, nodeKey)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%x\n", authorization)
+	return nil
+}
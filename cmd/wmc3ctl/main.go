@@ -0,0 +1,112 @@
+// A command line client for operating a wmc3 instance over NATS.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+const PRODUCT_NAME = "wmc3ctl"
+
+// How long to wait for wmc3 to answer a request.
+const REQUEST_TIMEOUT = 30 * time.Second
+
+// A subcommand of wmc3ctl. The function receives the remaining arguments and
+// a function to lazily obtain a NATS connection.
+type command struct {
+	description string
+	run         func(args []string, connect func() (*nats.Conn, error)) error
+}
+
+var commands = map[string]command{
+	"history":   {"search recorded client events", runHistory},
+	"keygen":    {"generate or encrypt a key file", runKeygen},
+	"authorize": {"authorize a C2 node identity with the admin key", runAuthorize},
+	"peers":     {"list or change the Yggdrasil peers of wmc3", runPeers},
+	"stats":     {"show statistics about the Yggdrasil node of wmc3", runStats},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s [flags] <command> [command flags]\n\nflags:\n", PRODUCT_NAME)
+	flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, "\ncommands:\n")
+	names := []string{}
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", name, commands[name].description)
+	}
+}
+
+func main() {
+	server := flag.String("server", envOr("WMC3CTL_SERVER", nats.DefaultURL), "NATS server URL of the wmc3 instance (env WMC3CTL_SERVER)")
+	user := flag.String("user", os.Getenv("WMC3CTL_USER"), "NATS username (env WMC3CTL_USER)")
+	pass := flag.String("pass", os.Getenv("WMC3CTL_PASS"), "NATS password (env WMC3CTL_PASS)")
+	nkeySeed := flag.String("nkey", os.Getenv("WMC3CTL_NKEY"), "path to an nkey seed file to authenticate with (env WMC3CTL_NKEY)")
+	caFile := flag.String("ca", os.Getenv("WMC3CTL_CA"), "path to a CA certificate to verify the server with (env WMC3CTL_CA)")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+
+	connect := func() (*nats.Conn, error) {
+		opts := []nats.Option{nats.Name(PRODUCT_NAME)}
+		if *user != "" {
+			opts = append(opts, nats.UserInfo(*user, *pass))
+		}
+		if *nkeySeed != "" {
+			opt, err := nats.NkeyOptionFromSeed(*nkeySeed)
+			if err != nil {
+				return nil, errors.Join(errors.New("failed to load nkey seed"), err)
+			}
+			opts = append(opts, opt)
+		}
+		if *caFile != "" {
+			opts = append(opts, nats.RootCAs(*caFile))
+		}
+		return nats.Connect(*server, opts...)
+	}
+
+	if err := cmd.run(flag.Args()[1:], connect); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", flag.Arg(0), err)
+		os.Exit(1)
+	}
+}
+
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// Send a JSON request to wmc3 and decode the JSON response into res.
+func request(nc *nats.Conn, subject string, req any, res any) error {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	msg, err := nc.Request(subject, data, REQUEST_TIMEOUT)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(msg.Data, res)
+}
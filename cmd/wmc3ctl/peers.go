@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"dev.l1qu1d.net/wraith-labs/wraith_module_comosum/cmd/internal/wire"
+	"dev.l1qu1d.net/wraith-labs/wraith_module_comosum/radio"
+	"github.com/nats-io/nats.go"
+)
+
+func runPeers(args []string, connect func() (*nats.Conn, error)) error {
+	fs := flag.NewFlagSet("peers", flag.ExitOnError)
+	instance := fs.String("instance", "", "name of the wmc3 instance to manage (any instance if empty)")
+	clearPeers := fs.Bool("clear", false, "allow set with no URIs, removing all configured peers")
+	allow := fs.String("allow", "", "comma-separated public keys which may peer inbound, for filter (anyone if empty)")
+	deny := fs.String("deny", "", "comma-separated public keys which may never peer inbound, for filter")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s peers [-instance name] [-clear] [-allow keys] [-deny keys] [list | add <uri>... | remove <uri>... | set [uri...] | filter]\n", PRODUCT_NAME)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	req := wire.PeersRequest{Op: wire.PEERS_OP_LIST, Clear: *clearPeers}
+	if fs.NArg() > 0 {
+		req.Op = fs.Arg(0)
+		req.URIs = fs.Args()[1:]
+	}
+	switch req.Op {
+	case wire.PEERS_OP_LIST:
+	case wire.PEERS_OP_ADD, wire.PEERS_OP_REMOVE:
+		if len(req.URIs) == 0 {
+			return fmt.Errorf("%s needs at least one peer URI", req.Op)
+		}
+	case wire.PEERS_OP_SET:
+		if len(req.URIs) == 0 && !req.Clear {
+			return errors.New("set needs at least one peer URI, or -clear to remove all peers")
+		}
+	case wire.PEERS_OP_FILTER:
+		filter := radio.PeerFilter{}
+		if *allow != "" {
+			filter.Allow = strings.Split(*allow, ",")
+		}
+		if *deny != "" {
+			filter.Deny = strings.Split(*deny, ",")
+		}
+		if err := filter.Validate(); err != nil {
+			return err
+		}
+		req.Filter = &filter
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	subject := wire.PEERS_SUBJECT
+	if *instance != "" {
+		subject += "." + *instance
+	}
+
+	nc, err := connect()
+	if err != nil {
+		return err
+	}
+	defer nc.Close()
+
+	result := wire.PeersResult{}
+	if err := request(nc, subject, req, &result); err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(result); err != nil {
+		return err
+	}
+	if result.Error != "" {
+		return errors.New(result.Error)
+	}
+
+	return nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+
+	"dev.l1qu1d.net/wraith-labs/wraith_module_comosum/cmd/internal/wire"
+	"github.com/nats-io/nats.go"
+)
+
+func runStats(args []string, connect func() (*nats.Conn, error)) error {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	instance := fs.String("instance", "", "name of the wmc3 instance to query (any instance if empty)")
+	fs.Parse(args)
+
+	subject := wire.STATS_SUBJECT
+	if *instance != "" {
+		subject += "." + *instance
+	}
+
+	nc, err := connect()
+	if err != nil {
+		return err
+	}
+	defer nc.Close()
+
+	result := wire.StatsResult{}
+	if err := request(nc, subject, struct{}{}, &result); err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(result)
+}
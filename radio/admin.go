@@ -0,0 +1,270 @@
+package radio
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/address"
+	"github.com/yggdrasil-network/yggdrasil-go/src/admin"
+	"github.com/yggdrasil-network/yggdrasil-go/src/core"
+	"github.com/yggdrasil-network/yggdrasil-go/src/multicast"
+	"github.com/yggdrasil-network/yggdrasil-go/src/version"
+)
+
+// An in-process counterpart of the Yggdrasil admin socket. It offers the same
+// commands with the same request and response types, but they are called
+// directly, so a node can be inspected without opening a socket. Commands can
+// only be run while the node is running.
+type AdminAPI struct {
+	node *Node
+
+	mu       sync.RWMutex
+	handlers map[string]adminHandler
+}
+
+type adminHandler struct {
+	desc string
+	args []string
+	fn   core.AddHandlerFunc
+}
+
+func newAdminAPI(n *Node) *AdminAPI {
+	return &AdminAPI{node: n, handlers: map[string]adminHandler{}}
+}
+
+// Register a command. This lets Yggdrasil modules add their own commands
+// through core.AddHandler, as they would to the admin socket.
+func (a *AdminAPI) AddHandler(name, desc string, args []string, fn core.AddHandlerFunc) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	name = strings.ToLower(name)
+	if _, ok := a.handlers[name]; ok {
+		return fmt.Errorf("handler %s already exists", name)
+	}
+	a.handlers[name] = adminHandler{desc: desc, args: args, fn: fn}
+	return nil
+}
+
+// Run a command. The arguments are encoded as JSON and decoded into the
+// command's request type, so they can be that type, a map or nil. The result
+// is the command's response type, such as *admin.GetSelfResponse.
+func (a *AdminAPI) Call(name string, args any) (any, error) {
+	// Keep the node from being closed under the command. Handlers must not
+	// take stateMu themselves.
+	a.node.stateMu.RLock()
+	defer a.node.stateMu.RUnlock()
+	if err := a.node.runningErr(); err != nil {
+		return nil, err
+	}
+
+	a.mu.RLock()
+	h, ok := a.handlers[strings.ToLower(name)]
+	a.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown command %s", name)
+	}
+
+	in := json.RawMessage("{}")
+	if args != nil {
+		var err error
+		if in, err = json.Marshal(args); err != nil {
+			return nil, err
+		}
+	}
+	return h.fn(in)
+}
+
+// List the available commands.
+func (a *AdminAPI) List() []admin.ListEntry {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	list := make([]admin.ListEntry, 0, len(a.handlers))
+	for name, h := range a.handlers {
+		list = append(list, admin.ListEntry{
+			Command:     name,
+			Description: h.desc,
+			Fields:      h.args,
+		})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Command < list[j].Command })
+	return list
+}
+
+// Wrap a typed handler as a core.AddHandlerFunc.
+func typedAdminHandler[Req any, Res any](fn func(*Req) (*Res, error)) core.AddHandlerFunc {
+	return func(in json.RawMessage) (any, error) {
+		req := new(Req)
+		if len(in) != 0 {
+			if err := json.Unmarshal(in, req); err != nil {
+				return nil, err
+			}
+		}
+		return fn(req)
+	}
+}
+
+// Register the node's own commands, mirroring those of the admin socket.
+func (n *Node) setupAdminAPI() error {
+	a := n.api
+
+	handlers := []struct {
+		name string
+		desc string
+		args []string
+		fn   core.AddHandlerFunc
+	}{
+		{"getSelf", "Show details about this node", []string{}, typedAdminHandler(n.adminGetSelf)},
+		{"getPeers", "Show directly connected peers", []string{}, typedAdminHandler(n.adminGetPeers)},
+		{"getSessions", "Show established traffic sessions with remote nodes", []string{}, typedAdminHandler(n.adminGetSessions)},
+		{"getPaths", "Show established paths through this node", []string{}, typedAdminHandler(n.adminGetPaths)},
+		{"getTree", "Show known Tree entries", []string{}, typedAdminHandler(n.adminGetTree)},
+		{"addPeer", "Add a peer to the peer list", []string{"uri"}, typedAdminHandler(n.adminAddPeer)},
+		{"removePeer", "Remove a peer from the peer list", []string{"uri"}, typedAdminHandler(n.adminRemovePeer)},
+		{"list", "List available commands", []string{}, func(json.RawMessage) (any, error) {
+			return &admin.ListResponse{List: a.List()}, nil
+		}},
+	}
+	for _, h := range handlers {
+		if err := a.AddHandler(h.name, h.desc, h.args, h.fn); err != nil {
+			return err
+		}
+	}
+
+	// Remote node info and debug commands.
+	return n.core.SetAdmin(a)
+}
+
+// Register the commands of the multicast module.
+func (n *Node) setupMulticastAdminAPI() error {
+	return n.api.AddHandler(
+		"getMulticastInterfaces", "Show which interfaces multicast is enabled on", []string{},
+		typedAdminHandler(func(*multicast.GetMulticastInterfacesRequest) (*multicast.GetMulticastInterfacesResponse, error) {
+			res := &multicast.GetMulticastInterfacesResponse{Interfaces: []string{}}
+			for _, intf := range n.multicast.Interfaces() {
+				res.Interfaces = append(res.Interfaces, intf.Name)
+			}
+			sort.Strings(res.Interfaces)
+			return res, nil
+		}),
+	)
+}
+
+func (n *Node) adminGetSelf(*admin.GetSelfRequest) (*admin.GetSelfResponse, error) {
+	self := n.core.GetSelf()
+	subnet := n.core.Subnet()
+	return &admin.GetSelfResponse{
+		BuildName:      version.BuildName(),
+		BuildVersion:   version.BuildVersion(),
+		PublicKey:      hex.EncodeToString(self.Key),
+		IPAddress:      n.core.Address().String(),
+		RoutingEntries: self.RoutingEntries,
+		Subnet:         subnet.String(),
+	}, nil
+}
+
+func (n *Node) adminGetPeers(*admin.GetPeersRequest) (*admin.GetPeersResponse, error) {
+	res := &admin.GetPeersResponse{Peers: []admin.PeerEntry{}}
+	for _, p := range n.core.GetPeers() {
+		peer := admin.PeerEntry{
+			URI:      p.URI,
+			Up:       p.Up,
+			Inbound:  p.Inbound,
+			Port:     p.Port,
+			Priority: uint64(p.Priority),
+			RXBytes:  admin.DataUnit(p.RXBytes),
+			TXBytes:  admin.DataUnit(p.TXBytes),
+			Uptime:   p.Uptime.Seconds(),
+		}
+		if len(p.Key) != 0 {
+			addr := address.AddrForKey(p.Key)
+			peer.PublicKey = hex.EncodeToString(p.Key)
+			peer.IPAddress = net.IP(addr[:]).String()
+		}
+		if p.LastError != nil {
+			peer.LastError = p.LastError.Error()
+			peer.LastErrorTime = time.Since(p.LastErrorTime)
+		}
+		res.Peers = append(res.Peers, peer)
+	}
+	sort.SliceStable(res.Peers, func(i, j int) bool {
+		if res.Peers[i].Port == res.Peers[j].Port {
+			return res.Peers[i].Priority < res.Peers[j].Priority
+		}
+		return res.Peers[i].Port < res.Peers[j].Port
+	})
+	return res, nil
+}
+
+func (n *Node) adminGetSessions(*admin.GetSessionsRequest) (*admin.GetSessionsResponse, error) {
+	res := &admin.GetSessionsResponse{Sessions: []admin.SessionEntry{}}
+	for _, s := range n.core.GetSessions() {
+		addr := address.AddrForKey(s.Key)
+		res.Sessions = append(res.Sessions, admin.SessionEntry{
+			IPAddress: net.IP(addr[:]).String(),
+			PublicKey: hex.EncodeToString(s.Key),
+			RXBytes:   admin.DataUnit(s.RXBytes),
+			TXBytes:   admin.DataUnit(s.TXBytes),
+			Uptime:    s.Uptime.Seconds(),
+		})
+	}
+	sort.SliceStable(res.Sessions, func(i, j int) bool {
+		return res.Sessions[i].PublicKey < res.Sessions[j].PublicKey
+	})
+	return res, nil
+}
+
+func (n *Node) adminGetPaths(*admin.GetPathsRequest) (*admin.GetPathsResponse, error) {
+	res := &admin.GetPathsResponse{Paths: []admin.PathEntry{}}
+	for _, p := range n.core.GetPaths() {
+		addr := address.AddrForKey(p.Key)
+		res.Paths = append(res.Paths, admin.PathEntry{
+			IPAddress: net.IP(addr[:]).String(),
+			PublicKey: hex.EncodeToString(p.Key),
+			Path:      p.Path,
+			Sequence:  p.Sequence,
+		})
+	}
+	sort.SliceStable(res.Paths, func(i, j int) bool {
+		return res.Paths[i].PublicKey < res.Paths[j].PublicKey
+	})
+	return res, nil
+}
+
+func (n *Node) adminGetTree(*admin.GetTreeRequest) (*admin.GetTreeResponse, error) {
+	res := &admin.GetTreeResponse{Tree: []admin.TreeEntry{}}
+	for _, t := range n.core.GetTree() {
+		addr := address.AddrForKey(t.Key)
+		res.Tree = append(res.Tree, admin.TreeEntry{
+			IPAddress: net.IP(addr[:]).String(),
+			PublicKey: hex.EncodeToString(t.Key),
+			Parent:    hex.EncodeToString(t.Parent),
+			Sequence:  t.Sequence,
+		})
+	}
+	sort.SliceStable(res.Tree, func(i, j int) bool {
+		return res.Tree[i].PublicKey < res.Tree[j].PublicKey
+	})
+	return res, nil
+}
+
+func (n *Node) adminAddPeer(req *admin.AddPeerRequest) (*admin.AddPeerResponse, error) {
+	if req.Sintf != "" {
+		return nil, fmt.Errorf("peering through a specific interface is not supported")
+	}
+	return &admin.AddPeerResponse{}, n.addPeer(req.Uri)
+}
+
+func (n *Node) adminRemovePeer(req *admin.RemovePeerRequest) (*admin.RemovePeerResponse, error) {
+	if req.Sintf != "" {
+		return nil, fmt.Errorf("peering through a specific interface is not supported")
+	}
+	return &admin.RemovePeerResponse{}, n.removePeer(req.Uri)
+}
@@ -0,0 +1,32 @@
+package radio
+
+import (
+	"crypto"
+	"crypto/ed25519"
+)
+
+// The Ed25519ctx context of node authorizations, which keeps them from being
+// mistaken for any other message signed by the admin key.
+const NODE_AUTHORIZATION_CONTEXT = "comosum node authorization v1"
+
+// Sign a statement that the Yggdrasil node with the given public key may act
+// as the C2. This lets clients find and trust a C2 node without it holding
+// the admin key.
+func AuthorizeNode(adminKey ed25519.PrivateKey, nodeKey ed25519.PublicKey) ([]byte, error) {
+	return adminKey.Sign(nil, nodeKey, &ed25519.Options{
+		Hash:    crypto.Hash(0),
+		Context: NODE_AUTHORIZATION_CONTEXT,
+	})
+}
+
+// Check that the Yggdrasil node with the given public key was authorized to
+// act as the C2 by the owner of the admin key.
+func VerifyNodeAuthorization(adminKey ed25519.PublicKey, nodeKey ed25519.PublicKey, authorization []byte) bool {
+	if len(adminKey) != ed25519.PublicKeySize || len(nodeKey) != ed25519.PublicKeySize {
+		return false
+	}
+	return ed25519.VerifyWithOptions(adminKey, nodeKey, authorization, &ed25519.Options{
+		Hash:    crypto.Hash(0),
+		Context: NODE_AUTHORIZATION_CONTEXT,
+	}) == nil
+}
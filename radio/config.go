@@ -0,0 +1,102 @@
+package radio
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/hjson/hjson-go/v4"
+	"github.com/yggdrasil-network/yggdrasil-go/src/config"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// Read a Yggdrasil configuration file, such as yggdrasil.conf, in HJSON or
+// JSON. Multicast, the admin socket and the MTU keep a Node's defaults unless
+// the file sets them: multicast and the admin socket stay off and the MTU is
+// DEFAULT_IF_MTU. Anything else the file leaves out gets Yggdrasil's
+// defaults, including a freshly generated private key.
+func LoadNodeConfig(path string) (*config.NodeConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	// Yggdrasil chokes on files too short to check for a byte order mark.
+	if len(bytes.TrimSpace(data)) < 2 {
+		return nil, errors.New("configuration file is empty")
+	}
+
+	cfg := new(config.NodeConfig)
+	if _, err := cfg.ReadFrom(bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+
+	// Yggdrasil's defaults turn multicast and the admin socket on, which a
+	// file that doesn't mention them shouldn't do.
+	keys, err := configKeys(data)
+	if err != nil {
+		return nil, err
+	}
+	if !keys["multicastinterfaces"] {
+		cfg.MulticastInterfaces = []config.MulticastInterfaceConfig{}
+	}
+	if !keys["adminlisten"] {
+		cfg.AdminListen = "none"
+	}
+	if !keys["ifmtu"] {
+		cfg.IfMTU = 0
+	}
+	return cfg, nil
+}
+
+// Get the names of the settings in a configuration file, in lower case since
+// Yggdrasil matches them regardless of case.
+func configKeys(data []byte) (map[string]bool, error) {
+	// Decode UTF-16 the way Yggdrasil does.
+	if bytes.HasPrefix(data, []byte{0xFF, 0xFE}) || bytes.HasPrefix(data, []byte{0xFE, 0xFF}) {
+		var err error
+		data, err = unicode.UTF16(unicode.BigEndian, unicode.UseBOM).NewDecoder().Bytes(data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	settings := map[string]any{}
+	if err := hjson.Unmarshal(data, &settings); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]bool, len(settings))
+	for key := range settings {
+		keys[strings.ToLower(key)] = true
+	}
+	return keys, nil
+}
+
+// Turn a Yggdrasil configuration into options for a Node. Yggdrasil's
+// NodeInfoPrivacy and IfName have no equivalent, since nodes always keep
+// their nodeinfo private and use a netstack instead of a TUN interface.
+func NodeOptionsFromConfig(cfg *config.NodeConfig) NodeOptions {
+	interfacePeers := make(map[string][]string, len(cfg.InterfacePeers))
+	for intf, peers := range cfg.InterfacePeers {
+		interfacePeers[intf] = slices.Clone(peers)
+	}
+
+	return NodeOptions{
+		PrivateKey:        ed25519.PrivateKey(slices.Clone(cfg.PrivateKey)),
+		Listen:            slices.Clone(cfg.Listen),
+		Peers:             slices.Clone(cfg.Peers),
+		InterfacePeers:    interfacePeers,
+		AllowedPublicKeys: slices.Clone(cfg.AllowedPublicKeys),
+		NodeInfo:          maps.Clone(cfg.NodeInfo),
+		Multicast: MulticastConfig{
+			Enabled:    len(cfg.MulticastInterfaces) != 0,
+			Interfaces: slices.Clone(cfg.MulticastInterfaces),
+		},
+		AdminListen: cfg.AdminListen,
+		IfMTU:       cfg.IfMTU,
+		LogLookups:  cfg.LogLookups,
+	}
+}
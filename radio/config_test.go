@@ -0,0 +1,66 @@
+package radio
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeConfigFile(t *testing.T, contents string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "yggdrasil.conf")
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadNodeConfigKeepsNodeDefaults(t *testing.T) {
+	cfg, err := LoadNodeConfig(writeConfigFile(t, `{
+		# Only peers, nothing else.
+		Peers: ["tcp://127.0.0.1:1"]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := NodeOptionsFromConfig(cfg)
+	if opts.Multicast.Enabled {
+		t.Errorf("multicast is enabled on %v", opts.Multicast.Interfaces)
+	}
+	if opts.AdminListen != "none" {
+		t.Errorf("admin socket listens on %q", opts.AdminListen)
+	}
+	if opts.IfMTU != 0 {
+		t.Errorf("MTU is %d, want the node default", opts.IfMTU)
+	}
+	if len(opts.Peers) != 1 || len(opts.PrivateKey) == 0 {
+		t.Errorf("peers %v or private key missing", opts.Peers)
+	}
+	if err := opts.Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLoadNodeConfigExplicitSettings(t *testing.T) {
+	cfg, err := LoadNodeConfig(writeConfigFile(t, `{
+		"multicastinterfaces": [{"Regex": "eth0", "Beacon": true, "Listen": true}],
+		"AdminListen": "tcp://localhost:9001",
+		"IfMTU": 1500
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := NodeOptionsFromConfig(cfg)
+	if !opts.Multicast.Enabled || len(opts.Multicast.Interfaces) != 1 || opts.Multicast.Interfaces[0].Regex != "eth0" {
+		t.Errorf("multicast settings were not kept: %+v", opts.Multicast)
+	}
+	if opts.AdminListen != "tcp://localhost:9001" {
+		t.Errorf("admin socket listens on %q", opts.AdminListen)
+	}
+	if opts.IfMTU != 1500 {
+		t.Errorf("MTU is %d, want 1500", opts.IfMTU)
+	}
+}
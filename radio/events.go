@@ -0,0 +1,55 @@
+package radio
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+const (
+	// The prefix of all NATS subjects client events are published on. The full
+	// subject is EVENTS_SUBJECT_PREFIX.<client>.<op>, see EventSubject.
+	EVENTS_SUBJECT_PREFIX = "comosum.events"
+)
+
+// Types of client events.
+const (
+	EVENT_OP_HEARTBEAT = "heartbeat"
+	EVENT_OP_EXCHANGE  = "exchange"
+	EVENT_OP_RESPONSE  = "response"
+	EVENT_OP_ERROR     = "error"
+)
+
+// Something that happened involving a client, as recorded by the C2.
+type Event struct {
+	// When the event happened.
+	Time time.Time `json:"time"`
+
+	// The hex-encoded public key of the client the event concerns.
+	Client string `json:"client"`
+
+	// The type of event, one of the EVENT_OP_* constants.
+	Op string `json:"op"`
+
+	// The SHM keys involved in the event, if any.
+	Keys []string `json:"keys,omitempty"`
+
+	// A description of what went wrong, for error events.
+	Error string `json:"error,omitempty"`
+
+	// Arbitrary event-specific data, such as the heartbeat or exchange packet.
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// Get the subject on which an event for the given client and op is published.
+// Empty values are replaced with a wildcard so the result can be used as a
+// subscription filter.
+func EventSubject(client, op string) string {
+	if client == "" {
+		client = "*"
+	}
+	if op == "" {
+		op = "*"
+	}
+	return strings.Join([]string{EVENTS_SUBJECT_PREFIX, client, op}, ".")
+}
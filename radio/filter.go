@@ -0,0 +1,114 @@
+package radio
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Which nodes may peer with a node inbound, by hex-encoded public key.
+// Outbound peerings are not affected, since the node chose those itself.
+//
+// Yggdrasil only knows how to allow keys, and only takes them when the node
+// starts, so the allowed keys the node started with are refused during the
+// peering handshake. Everything else, the deny list and any changes made with
+// SetPeerFilter included, is enforced by a PeerSupervisor, which reports
+// inbound peers the filter does not permit.
+type PeerFilter struct {
+	// If not empty, only these keys may peer inbound.
+	Allow []string `json:"allow,omitempty"`
+
+	// These keys may never peer inbound, even if allowed.
+	Deny []string `json:"deny,omitempty"`
+}
+
+// Check that all keys in the filter are valid.
+func (f PeerFilter) Validate() error {
+	for _, key := range f.Allow {
+		if k, err := hex.DecodeString(key); err != nil || len(k) != ed25519.PublicKeySize {
+			return fmt.Errorf("invalid allowed public key %q", key)
+		}
+	}
+	for _, key := range f.Deny {
+		if k, err := hex.DecodeString(key); err != nil || len(k) != ed25519.PublicKeySize {
+			return fmt.Errorf("invalid denied public key %q", key)
+		}
+	}
+	if len(f.Allow) != 0 && len(f.allowed()) == 0 {
+		return errors.New("every allowed public key is also denied")
+	}
+	return nil
+}
+
+// Whether the node with the given key may peer inbound.
+func (f PeerFilter) Permits(key ed25519.PublicKey) bool {
+	k := hex.EncodeToString(key)
+	match := func(key string) bool { return strings.EqualFold(key, k) }
+	if slices.ContainsFunc(f.Deny, match) {
+		return false
+	}
+	return len(f.Allow) == 0 || slices.ContainsFunc(f.Allow, match)
+}
+
+// Get the keys Yggdrasil should allow to peer inbound for the filter to hold.
+// Empty means anyone.
+func (f PeerFilter) allowed() []ed25519.PublicKey {
+	keys := []ed25519.PublicKey{}
+	for _, key := range f.Allow {
+		k, err := hex.DecodeString(key)
+		if err != nil || !f.Permits(k) {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// Get the node's peer filter.
+func (n *Node) PeerFilter() PeerFilter {
+	n.stateMu.RLock()
+	defer n.stateMu.RUnlock()
+
+	return PeerFilter{
+		Allow: slices.Clone(n.filter.Allow),
+		Deny:  slices.Clone(n.filter.Deny),
+	}
+}
+
+// Replace the node's peer filter. Once the node is running, the filter can't
+// permit keys Yggdrasil was not started with, since Yggdrasil would refuse
+// them anyway; the node has to be restarted for that.
+func (n *Node) SetPeerFilter(f PeerFilter) error {
+	if err := f.Validate(); err != nil {
+		return err
+	}
+
+	n.stateMu.Lock()
+	defer n.stateMu.Unlock()
+
+	switch n.state {
+	case NODE_STATE_CLOSED:
+		return ErrNodeClosed
+	case NODE_STATE_RUNNING:
+		if len(n.admitted) == 0 {
+			break
+		}
+		if len(f.Allow) == 0 {
+			return errors.New("can't allow every key to peer without a restart")
+		}
+		for _, key := range f.allowed() {
+			if !slices.ContainsFunc(n.admitted, func(k ed25519.PublicKey) bool { return k.Equal(key) }) {
+				return fmt.Errorf("can't allow public key %s to peer without a restart", hex.EncodeToString(key))
+			}
+		}
+	}
+
+	n.filter = PeerFilter{
+		Allow: slices.Clone(f.Allow),
+		Deny:  slices.Clone(f.Deny),
+	}
+	return nil
+}
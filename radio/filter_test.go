@@ -0,0 +1,138 @@
+package radio
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestKey(t *testing.T) ed25519.PublicKey {
+	t.Helper()
+
+	key, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestPeerFilter(t *testing.T) {
+	a, b := newTestKey(t), newTestKey(t)
+	ha, hb := hex.EncodeToString(a), hex.EncodeToString(b)
+
+	for _, tc := range []struct {
+		name      string
+		filter    PeerFilter
+		permitsA  bool
+		permitsB  bool
+		wantValid bool
+	}{
+		{"empty", PeerFilter{}, true, true, true},
+		{"allow", PeerFilter{Allow: []string{ha}}, true, false, true},
+		{"deny only", PeerFilter{Deny: []string{strings.ToUpper(hb)}}, true, false, true},
+		{"allow and deny", PeerFilter{Allow: []string{ha, hb}, Deny: []string{hb}}, true, false, true},
+		{"all denied", PeerFilter{Allow: []string{ha}, Deny: []string{ha}}, false, false, false},
+		{"invalid key", PeerFilter{Deny: []string{"nope"}}, true, true, false},
+	} {
+		if err := tc.filter.Validate(); (err == nil) != tc.wantValid {
+			t.Errorf("%s: Validate returned %v", tc.name, err)
+		}
+		if got := tc.filter.Permits(a); got != tc.permitsA {
+			t.Errorf("%s: Permits(a) is %v", tc.name, got)
+		}
+		if got := tc.filter.Permits(b); got != tc.permitsB {
+			t.Errorf("%s: Permits(b) is %v", tc.name, got)
+		}
+	}
+}
+
+func TestNodeSetPeerFilter(t *testing.T) {
+	a, b := hex.EncodeToString(newTestKey(t)), hex.EncodeToString(newTestKey(t))
+
+	opts := newTestOptions(t)
+	opts.AllowedPublicKeys = []string{a, b}
+	n := newRunningNode(t, opts)
+
+	// Narrowing works while running.
+	if err := n.SetPeerFilter(PeerFilter{Allow: []string{a}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := n.PeerFilter(); len(got.Allow) != 1 || got.Allow[0] != a {
+		t.Errorf("peer filter is %+v", got)
+	}
+	if err := n.SetPeerFilter(PeerFilter{Allow: []string{a, b}, Deny: []string{b}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Widening beyond what Yggdrasil started with needs a restart.
+	if err := n.SetPeerFilter(PeerFilter{}); err == nil {
+		t.Error("allowed everyone without a restart")
+	}
+	c := hex.EncodeToString(newTestKey(t))
+	if err := n.SetPeerFilter(PeerFilter{Allow: []string{a, c}}); err == nil {
+		t.Error("allowed a new key without a restart")
+	}
+	if err := n.SetPeerFilter(PeerFilter{Deny: []string{"nope"}}); err == nil {
+		t.Error("set an invalid filter")
+	}
+
+	// Anything goes if Yggdrasil lets everyone in.
+	n = newRunningNode(t, newTestOptions(t))
+	if err := n.SetPeerFilter(PeerFilter{Allow: []string{c}}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPeerSupervisorReportsDenied(t *testing.T) {
+	opts := newTestOptions(t)
+	opts.Listen = []string{"tcp://127.0.0.1:0"}
+	n := newRunningNode(t, opts)
+
+	peerOpts := newTestOptions(t)
+	peerOpts.Peers = []string{"tcp://" + n.Listeners()[0].String()}
+	peer := newRunningNode(t, peerOpts)
+	peerKey := hex.EncodeToString(peer.Config().PrivateKey[ed25519.SeedSize:])
+
+	// Deny the peer once it is connected, as a reload would.
+	for deadline := time.Now().Add(5 * time.Second); len(n.Peers()) == 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("peer did not connect")
+		}
+	}
+	if err := n.SetPeerFilter(PeerFilter{Deny: []string{peerKey}}); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewPeerSupervisor(n, PeerSupervisorOptions{Interval: 10 * time.Millisecond})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(10 * time.Millisecond) {
+		denied := 0
+		for _, entry := range s.History() {
+			if entry.Event == PEER_EVENT_DENIED && strings.Contains(entry.Error, peerKey) {
+				denied++
+			}
+		}
+		if denied > 1 {
+			t.Fatalf("denied peer was reported %d times", denied)
+		}
+		if time.Now().After(deadline) {
+			if denied == 0 {
+				t.Fatalf("denied peer was not reported; history: %+v", s.History())
+			}
+			return
+		}
+	}
+}
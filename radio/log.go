@@ -0,0 +1,115 @@
+package radio
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/gologme/log"
+)
+
+// The level of Yggdrasil's trace messages, which are noisier than debug ones.
+const LOG_LEVEL_TRACE = slog.LevelDebug - 4
+
+// Get a logger that drops everything.
+func DiscardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.LevelError + 1}))
+}
+
+// Passes Yggdrasil's log messages on to a slog logger, keeping their levels.
+type YggdrasilLogger struct {
+	logger *slog.Logger
+}
+
+func NewYggdrasilLogger(logger *slog.Logger) *YggdrasilLogger {
+	return &YggdrasilLogger{logger: logger}
+}
+
+func (l *YggdrasilLogger) log(level slog.Level, msg string) {
+	l.logger.Log(context.Background(), level, strings.TrimSuffix(msg, "\n"))
+}
+
+func (l *YggdrasilLogger) Printf(format string, v ...any) {
+	l.log(slog.LevelInfo, fmt.Sprintf(format, v...))
+}
+
+func (l *YggdrasilLogger) Println(v ...any) {
+	l.log(slog.LevelInfo, fmt.Sprintln(v...))
+}
+
+func (l *YggdrasilLogger) Infof(format string, v ...any) {
+	l.log(slog.LevelInfo, fmt.Sprintf(format, v...))
+}
+
+func (l *YggdrasilLogger) Infoln(v ...any) {
+	l.log(slog.LevelInfo, fmt.Sprintln(v...))
+}
+
+func (l *YggdrasilLogger) Warnf(format string, v ...any) {
+	l.log(slog.LevelWarn, fmt.Sprintf(format, v...))
+}
+
+func (l *YggdrasilLogger) Warnln(v ...any) {
+	l.log(slog.LevelWarn, fmt.Sprintln(v...))
+}
+
+func (l *YggdrasilLogger) Errorf(format string, v ...any) {
+	l.log(slog.LevelError, fmt.Sprintf(format, v...))
+}
+
+func (l *YggdrasilLogger) Errorln(v ...any) {
+	l.log(slog.LevelError, fmt.Sprintln(v...))
+}
+
+func (l *YggdrasilLogger) Debugf(format string, v ...any) {
+	l.log(slog.LevelDebug, fmt.Sprintf(format, v...))
+}
+
+func (l *YggdrasilLogger) Debugln(v ...any) {
+	l.log(slog.LevelDebug, fmt.Sprintln(v...))
+}
+
+func (l *YggdrasilLogger) Traceln(v ...any) {
+	l.log(LOG_LEVEL_TRACE, fmt.Sprintln(v...))
+}
+
+// Get a gologme logger writing to the same slog logger, for the Yggdrasil
+// modules which insist on one. The level is recovered from the prefix
+// gologme puts in front of each line.
+func (l *YggdrasilLogger) gologme() *log.Logger {
+	logger := log.New(gologmeWriter{l}, "", 0)
+	logger.EnableFormattedPrefix()
+	for _, level := range []string{"error", "warn", "info", "debug", "trace"} {
+		logger.EnableLevel(level)
+	}
+	return logger
+}
+
+var gologmeLevels = map[string]slog.Level{
+	"PANIC": slog.LevelError,
+	"FATAL": slog.LevelError,
+	"ERROR": slog.LevelError,
+	"WARN":  slog.LevelWarn,
+	"INFO":  slog.LevelInfo,
+	"DEBUG": slog.LevelDebug,
+	"TRACE": LOG_LEVEL_TRACE,
+}
+
+type gologmeWriter struct {
+	logger *YggdrasilLogger
+}
+
+func (w gologmeWriter) Write(p []byte) (int, error) {
+	msg, level := string(p), slog.LevelInfo
+	if rest, ok := strings.CutPrefix(msg, "["); ok {
+		if name, rest, ok := strings.Cut(rest, "]"); ok {
+			if l, ok := gologmeLevels[name]; ok {
+				msg, level = strings.TrimLeft(rest, " "), l
+			}
+		}
+	}
+	w.logger.log(level, msg)
+	return len(p), nil
+}
@@ -5,9 +5,10 @@ package radio
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
 	"strconv"
+	"sync"
 
 	"github.com/yggdrasil-network/yggdrasil-go/src/core"
 	"github.com/yggdrasil-network/yggdrasil-go/src/ipv6rwc"
@@ -22,17 +23,23 @@ import (
 )
 
 type YggdrasilNIC struct {
-	stack      *YggdrasilNetstack
-	ipv6rwc    *ipv6rwc.ReadWriteCloser
-	dispatcher stack.NetworkDispatcher
-	readBuf    []byte
-	writeBuf   []byte
+	stack    *YggdrasilNetstack
+	ipv6rwc  *ipv6rwc.ReadWriteCloser
+	readBuf  []byte
+	writeBuf []byte
+
+	// Set by the stack while the NIC is attached. Packets are read from
+	// Yggdrasil in a goroutine of our own, so this needs guarding.
+	dispatcherMu sync.RWMutex
+	dispatcher   stack.NetworkDispatcher
 }
 
-func (s *YggdrasilNetstack) NewYggdrasilNIC(ygg *core.Core) tcpip.Error {
+func (s *YggdrasilNetstack) NewYggdrasilNIC(ygg *core.Core, mtu uint64) tcpip.Error {
 	rwc := ipv6rwc.NewReadWriteCloser(ygg)
-	mtu := rwc.MTU()
+	rwc.SetMTU(mtu)
+	mtu = rwc.MTU()
 	nic := &YggdrasilNIC{
+		stack:    s,
 		ipv6rwc:  rwc,
 		readBuf:  make([]byte, mtu),
 		writeBuf: make([]byte, mtu),
@@ -46,13 +53,19 @@ func (s *YggdrasilNetstack) NewYggdrasilNIC(ygg *core.Core) tcpip.Error {
 		for {
 			rx, err = nic.ipv6rwc.Read(nic.readBuf)
 			if err != nil {
-				log.Println(err)
+				// This is how the loop ends when the node is closed.
+				s.logger.Debug("stopped reading packets from Yggdrasil", "err", err)
 				break
 			}
+			// The NIC may have been detached by the stack shutting down.
+			dispatcher := nic.getDispatcher()
+			if dispatcher == nil {
+				continue
+			}
 			pkb := stack.NewPacketBuffer(stack.PacketBufferOptions{
 				Payload: buffer.MakeWithData(nic.readBuf[:rx]),
 			})
-			nic.dispatcher.DeliverNetworkPacket(ipv6.ProtocolNumber, pkb)
+			dispatcher.DeliverNetworkPacket(ipv6.ProtocolNumber, pkb)
 		}
 	}()
 	_, snet, err := net.ParseCIDR("0200::/7")
@@ -86,9 +99,21 @@ func (s *YggdrasilNetstack) NewYggdrasilNIC(ygg *core.Core) tcpip.Error {
 	return nil
 }
 
-func (e *YggdrasilNIC) Attach(dispatcher stack.NetworkDispatcher) { e.dispatcher = dispatcher }
+func (e *YggdrasilNIC) Attach(dispatcher stack.NetworkDispatcher) {
+	e.dispatcherMu.Lock()
+	defer e.dispatcherMu.Unlock()
+
+	e.dispatcher = dispatcher
+}
+
+func (e *YggdrasilNIC) getDispatcher() stack.NetworkDispatcher {
+	e.dispatcherMu.RLock()
+	defer e.dispatcherMu.RUnlock()
+
+	return e.dispatcher
+}
 
-func (e *YggdrasilNIC) IsAttached() bool { return e.dispatcher != nil }
+func (e *YggdrasilNIC) IsAttached() bool { return e.getDispatcher() != nil }
 
 func (e *YggdrasilNIC) MTU() uint32 { return uint32(e.ipv6rwc.MTU()) }
 
@@ -108,12 +133,12 @@ func (e *YggdrasilNIC) WritePackets(
 		vv := pkt.ToView()
 		n, err := vv.Read(e.writeBuf)
 		if err != nil {
-			log.Println(err)
+			e.stack.logger.Warn("failed to read outgoing packet", "err", err)
 			return i - 1, &tcpip.ErrAborted{}
 		}
 		_, err = e.ipv6rwc.Write(e.writeBuf[:n])
 		if err != nil {
-			log.Println(err)
+			e.stack.logger.Warn("failed to write packet to Yggdrasil", "err", err)
 			return i - 1, &tcpip.ErrAborted{}
 		}
 	}
@@ -138,14 +163,15 @@ func (e *YggdrasilNIC) ParseHeader(*stack.PacketBuffer) bool {
 
 func (e *YggdrasilNIC) Close() error {
 	e.stack.stack.RemoveNIC(1)
-	e.dispatcher = nil
+	e.Attach(nil)
 	return nil
 }
 
 ////////////////////////
 
 type YggdrasilNetstack struct {
-	stack *stack.Stack
+	stack  *stack.Stack
+	logger *slog.Logger
 }
 
 func CreateYggdrasilNetstack(ygg *Node) (*YggdrasilNetstack, error) {
@@ -155,18 +181,33 @@ func CreateYggdrasilNetstack(ygg *Node) (*YggdrasilNetstack, error) {
 			TransportProtocols: []stack.TransportProtocolFactory{tcp.NewProtocol, icmp.NewProtocol6},
 			HandleLocal:        true,
 		}),
+		logger: ygg.logger,
 	}
 	if s.stack.HandleLocal() {
 		s.stack.AllowICMPMessage()
 	} else if err := s.stack.SetForwardingDefaultAndAllNICs(ipv6.ProtocolNumber, true); err != nil {
 		panic(err)
 	}
-	if err := s.NewYggdrasilNIC(ygg.core); err != nil {
+	if err := s.NewYggdrasilNIC(ygg.core, ygg.config.IfMTU); err != nil {
 		return nil, fmt.Errorf("s.NewYggdrasilNIC: %s", err.String())
 	}
 	return s, nil
 }
 
+// TCP statistics of the network stack.
+func (s *YggdrasilNetstack) TCPStats() tcpip.TCPStats {
+	return s.stack.Stats().TCP
+}
+
+// Close all endpoints and NICs of the network stack and wait for its workers to
+// exit. The underlying Yggdrasil node is left running and must be closed
+// separately.
+func (s *YggdrasilNetstack) Close() error {
+	s.stack.Close()
+	s.stack.Wait()
+	return nil
+}
+
 func convertToFullAddr(ip net.IP, port int) (tcpip.FullAddress, tcpip.NetworkProtocolNumber, error) {
 	addr := tcpip.Address{}
 	ip16 := ip.To16()
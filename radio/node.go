@@ -3,14 +3,16 @@
 package radio
 
 import (
-	"context"
 	"crypto/ed25519"
-	"encoding/hex"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net"
+	"net/url"
 	"regexp"
-
-	"github.com/gologme/log"
+	"slices"
+	"sort"
+	"sync"
 
 	"github.com/yggdrasil-network/yggdrasil-go/src/admin"
 	"github.com/yggdrasil-network/yggdrasil-go/src/config"
@@ -18,106 +20,571 @@ import (
 	"github.com/yggdrasil-network/yggdrasil-go/src/multicast"
 )
 
+// The lifecycle states of a Node. A node only ever moves forward through
+// them.
+type NodeState int
+
+const (
+	// Created, but not configured yet.
+	NODE_STATE_NEW NodeState = iota
+	// Configured with GenerateConfig and ready to Run.
+	NODE_STATE_CONFIGURED
+	// Started by Run.
+	NODE_STATE_RUNNING
+	// Closed, or failed to start. A closed node can't be started again.
+	NODE_STATE_CLOSED
+)
+
+func (s NodeState) String() string {
+	switch s {
+	case NODE_STATE_NEW:
+		return "new"
+	case NODE_STATE_CONFIGURED:
+		return "configured"
+	case NODE_STATE_RUNNING:
+		return "running"
+	case NODE_STATE_CLOSED:
+		return "closed"
+	default:
+		return fmt.Sprintf("NodeState(%d)", int(s))
+	}
+}
+
+// The MTU of the netstack when none is configured. This is the smallest MTU
+// IPv6 allows.
+const DEFAULT_IF_MTU = 1280
+
+var (
+	ErrNodeNotRunning = errors.New("node is not running")
+	ErrNodeClosed     = errors.New("node is closed")
+)
+
 type Node struct {
 	core      *core.Core
-	ctx       context.Context
-	cancel    context.CancelFunc
-	logger    *log.Logger
+	logger    *slog.Logger
 	config    *config.NodeConfig
 	multicast *multicast.Multicast
 	admin     *admin.AdminSocket
+	api       *AdminAPI
+	// The listeners for peerings which started successfully.
+	listeners []*core.Listener
+
+	// The persistent outbound peers the node was configured with or told to
+	// add since, keyed by normalised URI.
+	peersMu sync.Mutex
+	peers   map[string]*url.URL
+
+	filter PeerFilter
+	// The keys Yggdrasil was told to allow to peer inbound when it started.
+	// Empty means anyone.
+	admitted []ed25519.PublicKey
+
+	stateMu sync.RWMutex
+	state   NodeState
+	// Closed once the node is closed and Yggdrasil has shut down.
+	done chan struct{}
+}
+
+// Multicast peer discovery settings. Multicast makes a node easy to spot on
+// the local network, so it is off unless explicitly enabled.
+type MulticastConfig struct {
+	Enabled bool
+
+	// The interfaces to use multicast on. If empty, the platform defaults are
+	// used.
+	Interfaces []config.MulticastInterfaceConfig
 }
 
-func NewNode(logger *log.Logger) *Node {
-	ctx, cancel := context.WithCancel(context.Background())
-	return &Node{
-		ctx:       ctx,
-		cancel:    cancel,
-		logger:    logger,
-		multicast: &multicast.Multicast{},
-		admin:     &admin.AdminSocket{},
+// Create a node which logs to the given logger, or nowhere if it is nil.
+func NewNode(logger *slog.Logger) *Node {
+	if logger == nil {
+		logger = DiscardLogger()
+	}
+	n := &Node{
+		logger: logger,
+		admin:  &admin.AdminSocket{},
+		peers:  map[string]*url.URL{},
+		done:   make(chan struct{}),
+	}
+	n.api = newAdminAPI(n)
+	return n
+}
+
+// Stop the node and release everything it holds. Closing a node that is
+// already closed does nothing, and a node that never ran can be closed too.
+func (n *Node) Close() error {
+	n.stateMu.Lock()
+	defer n.stateMu.Unlock()
+
+	if n.state == NODE_STATE_CLOSED {
+		return nil
 	}
+	return n.shutdown()
 }
 
-func (n *Node) Close() {
-	n.cancel()
-	_ = n.admin.Stop()
-	_ = n.multicast.Stop()
-	_ = n.core.Close()
+// Stop whatever parts of the node are running. The caller must hold stateMu.
+func (n *Node) shutdown() error {
+	n.state = NODE_STATE_CLOSED
+
+	errs := []error{n.admin.Stop()}
+	if n.multicast != nil {
+		errs = append(errs, n.multicast.Stop())
+	}
+	if n.core != nil {
+		errs = append(errs, n.core.Close())
+	}
+	close(n.done)
+
+	return errors.Join(errs...)
 }
 
+// Get a channel which is closed once the node has been closed and Yggdrasil
+// has shut down.
 func (n *Node) Done() <-chan struct{} {
-	return n.ctx.Done()
+	return n.done
+}
+
+// Get the node's current lifecycle state.
+func (n *Node) State() NodeState {
+	n.stateMu.RLock()
+	defer n.stateMu.RUnlock()
+
+	return n.state
+}
+
+// Get ErrNodeNotRunning or ErrNodeClosed unless the node is running. The
+// caller must hold stateMu.
+func (n *Node) runningErr() error {
+	switch n.state {
+	case NODE_STATE_RUNNING:
+		return nil
+	case NODE_STATE_CLOSED:
+		return ErrNodeClosed
+	default:
+		return ErrNodeNotRunning
+	}
 }
 
 func (n *Node) Address() (net.IP, net.IPNet) {
+	n.stateMu.RLock()
+	defer n.stateMu.RUnlock()
+
+	return n.address()
+}
+
+// Like Address, for callers which already hold stateMu.
+func (n *Node) address() (net.IP, net.IPNet) {
+	if n.core == nil {
+		return nil, net.IPNet{}
+	}
 	address, subnet := n.core.Address(), n.core.Subnet()
 	return address, subnet
 }
 
+// Get the addresses the node is listening for peerings on. Listeners which
+// failed to start are left out.
+func (n *Node) Listeners() []net.Addr {
+	n.stateMu.RLock()
+	defer n.stateMu.RUnlock()
+
+	if n.runningErr() != nil {
+		return []net.Addr{}
+	}
+	addrs := make([]net.Addr, 0, len(n.listeners))
+	for _, listener := range n.listeners {
+		addrs = append(addrs, listener.Addr())
+	}
+	return addrs
+}
+
+func (n *Node) Peers() []core.PeerInfo {
+	n.stateMu.RLock()
+	defer n.stateMu.RUnlock()
+
+	if n.runningErr() != nil {
+		return []core.PeerInfo{}
+	}
+	return n.core.GetPeers()
+}
+
+// Get the persistent outbound peers the node is currently configured with, not
+// including interface peers.
+func (n *Node) ConfiguredPeers() []string {
+	n.peersMu.Lock()
+	defer n.peersMu.Unlock()
+
+	uris := make([]string, 0, len(n.peers))
+	for uri := range n.peers {
+		uris = append(uris, uri)
+	}
+	sort.Strings(uris)
+	return uris
+}
+
+// Start peering with the given URI. The node keeps reconnecting to the peer
+// until it is removed.
+func (n *Node) AddPeer(uri string) error {
+	n.stateMu.RLock()
+	defer n.stateMu.RUnlock()
+
+	if err := n.runningErr(); err != nil {
+		return err
+	}
+	return n.addPeer(uri)
+}
+
+// Like AddPeer, for callers which already hold stateMu and have checked that
+// the node is running.
+func (n *Node) addPeer(uri string) error {
+	u, err := parsePeerURI(uri)
+	if err != nil {
+		return err
+	}
+
+	n.peersMu.Lock()
+	defer n.peersMu.Unlock()
+
+	if err := n.core.AddPeer(u, ""); err != nil {
+		return err
+	}
+	n.peers[u.String()] = u
+	return nil
+}
+
+// Stop peering with the given URI and disconnect from it.
+func (n *Node) RemovePeer(uri string) error {
+	n.stateMu.RLock()
+	defer n.stateMu.RUnlock()
+
+	if err := n.runningErr(); err != nil {
+		return err
+	}
+	return n.removePeer(uri)
+}
+
+// Like RemovePeer, for callers which already hold stateMu and have checked
+// that the node is running.
+func (n *Node) removePeer(uri string) error {
+	u, err := parsePeerURI(uri)
+	if err != nil {
+		return err
+	}
+
+	n.peersMu.Lock()
+	defer n.peersMu.Unlock()
+
+	if err := n.core.RemovePeer(u, ""); err != nil {
+		return err
+	}
+	delete(n.peers, u.String())
+	return nil
+}
+
+// Replace the configured peers with the given ones, adding new peers before
+// removing old ones so connectivity is kept where possible.
+func (n *Node) SetPeers(uris []string) error {
+	n.stateMu.RLock()
+	defer n.stateMu.RUnlock()
+
+	if err := n.runningErr(); err != nil {
+		return err
+	}
+	wanted := map[string]*url.URL{}
+	for _, uri := range uris {
+		u, err := parsePeerURI(uri)
+		if err != nil {
+			return err
+		}
+		wanted[u.String()] = u
+	}
+
+	n.peersMu.Lock()
+	defer n.peersMu.Unlock()
+
+	errs := []error{}
+	for key, u := range wanted {
+		if _, ok := n.peers[key]; ok {
+			continue
+		}
+		if err := n.core.AddPeer(u, ""); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		n.peers[key] = u
+	}
+	for key, u := range n.peers {
+		if _, ok := wanted[key]; ok {
+			continue
+		}
+		if err := n.core.RemovePeer(u, ""); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		delete(n.peers, key)
+	}
+
+	return errors.Join(errs...)
+}
+
+func parsePeerURI(uri string) (*url.URL, error) {
+	if err := validateURI(uri); err != nil {
+		return nil, fmt.Errorf("invalid peer %q: %w", uri, err)
+	}
+	return url.Parse(uri)
+}
+
 func (n *Node) Admin() *admin.AdminSocket {
 	return n.admin
 }
 
-func (n *Node) GenerateConfig(privkey ed25519.PrivateKey, listen []string, peers []string, debugSocket string) {
+// Get the node's in-process admin API. Unlike the admin socket, it is always
+// available while the node is running.
+func (n *Node) AdminAPI() *AdminAPI {
+	return n.api
+}
+
+// Everything needed to configure a Node. Only PrivateKey is required.
+type NodeOptions struct {
+	// The node's identity, which also determines its address.
+	PrivateKey ed25519.PrivateKey
+
+	// URIs to listen for peerings on, such as tls://0.0.0.0:0.
+	Listen []string
+
+	// URIs of peers to connect to on startup.
+	Peers []string
+
+	// URIs of peers to connect to through a specific network interface, keyed
+	// by interface name.
+	InterfacePeers map[string][]string
+
+	// If not empty, only peers with these hex-encoded public keys may peer
+	// with the node inbound. This does not affect who can talk to it over the
+	// network.
+	AllowedPublicKeys []string
+
+	// Peers with these hex-encoded public keys may never peer with the node
+	// inbound. Yggdrasil can't refuse them by itself, so this is up to a
+	// PeerSupervisor; see PeerFilter.
+	DeniedPublicKeys []string
+
+	// Arbitrary information the node shares when asked for it. Yggdrasil's
+	// own build and platform details are never included.
+	NodeInfo map[string]any
+
+	Multicast MulticastConfig
+
+	// Where to open the Yggdrasil admin socket, such as unix:///run/ygg.sock or
+	// tcp://localhost:9001. Empty or "none" disables it.
+	AdminListen string
+
+	// Whether the admin socket logs lookups of other nodes.
+	LogLookups bool
+
+	// The MTU of the node's netstack. Zero means DEFAULT_IF_MTU.
+	IfMTU uint64
+}
+
+// Check the options for mistakes that would otherwise only surface when the
+// node is started, or not at all.
+func (o NodeOptions) Validate() error {
+	if len(o.PrivateKey) != ed25519.PrivateKeySize {
+		return fmt.Errorf("incorrect private key size (is %d, should be %d)", len(o.PrivateKey), ed25519.PrivateKeySize)
+	}
+	for _, uri := range o.Listen {
+		if err := validateURI(uri); err != nil {
+			return fmt.Errorf("invalid listen address %q: %w", uri, err)
+		}
+	}
+	for _, uri := range o.Peers {
+		if err := validateURI(uri); err != nil {
+			return fmt.Errorf("invalid peer %q: %w", uri, err)
+		}
+	}
+	for intf, peers := range o.InterfacePeers {
+		for _, uri := range peers {
+			if err := validateURI(uri); err != nil {
+				return fmt.Errorf("invalid peer %q for interface %s: %w", uri, intf, err)
+			}
+		}
+	}
+	if err := (PeerFilter{Allow: o.AllowedPublicKeys, Deny: o.DeniedPublicKeys}).Validate(); err != nil {
+		return err
+	}
+	for _, intf := range o.Multicast.Interfaces {
+		if _, err := regexp.Compile(intf.Regex); err != nil {
+			return fmt.Errorf("invalid multicast interface regex %q: %w", intf.Regex, err)
+		}
+		if intf.Priority > 255 {
+			return fmt.Errorf("invalid multicast priority %d for %q, must be at most 255", intf.Priority, intf.Regex)
+		}
+	}
+	if o.AdminListen != "" && o.AdminListen != "none" {
+		if err := validateURI(o.AdminListen); err != nil {
+			return fmt.Errorf("invalid admin socket address %q: %w", o.AdminListen, err)
+		}
+	}
+
+	if o.IfMTU != 0 {
+		if maxMTU := config.GetDefaults().MaximumIfMTU; o.IfMTU < DEFAULT_IF_MTU || o.IfMTU > maxMTU {
+			return fmt.Errorf("invalid MTU %d, must be between %d and %d", o.IfMTU, DEFAULT_IF_MTU, maxMTU)
+		}
+	}
+
+	return nil
+}
+
+func validateURI(uri string) error {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return err
+	}
+	if u.Scheme == "" {
+		return fmt.Errorf("missing scheme")
+	}
+	return nil
+}
+
+// Validate the options and turn them into the node's configuration. This must
+// be called before Run, and can't be called after.
+func (n *Node) GenerateConfig(opts NodeOptions) error {
+	n.stateMu.Lock()
+	defer n.stateMu.Unlock()
+
+	if n.state != NODE_STATE_NEW && n.state != NODE_STATE_CONFIGURED {
+		return fmt.Errorf("cannot configure a node that is %s", n.state)
+	}
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
 	// Get the defaults for the platform.
 	defaults := config.GetDefaults()
 
 	// Create a node configuration and populate it.
 	cfg := new(config.NodeConfig)
-	cfg.PrivateKey = config.KeyBytes(privkey)
-	cfg.Listen = listen
-	cfg.AdminListen = debugSocket
-	cfg.Peers = peers
-	cfg.InterfacePeers = map[string][]string{}
-	cfg.AllowedPublicKeys = []string{}
-	cfg.MulticastInterfaces = defaults.DefaultMulticastInterfaces
+	cfg.PrivateKey = config.KeyBytes(opts.PrivateKey)
+	cfg.Listen = opts.Listen
+	cfg.AdminListen = opts.AdminListen
+	if cfg.AdminListen == "" {
+		cfg.AdminListen = "none"
+	}
+	cfg.Peers = opts.Peers
+	cfg.InterfacePeers = opts.InterfacePeers
+	if cfg.InterfacePeers == nil {
+		cfg.InterfacePeers = map[string][]string{}
+	}
+	cfg.AllowedPublicKeys = opts.AllowedPublicKeys
+	if cfg.AllowedPublicKeys == nil {
+		cfg.AllowedPublicKeys = []string{}
+	}
+	cfg.MulticastInterfaces = []config.MulticastInterfaceConfig{}
+	if opts.Multicast.Enabled {
+		cfg.MulticastInterfaces = opts.Multicast.Interfaces
+		if len(cfg.MulticastInterfaces) == 0 {
+			cfg.MulticastInterfaces = defaults.DefaultMulticastInterfaces
+		}
+	}
 	cfg.IfName = "none"
-	cfg.IfMTU = defaults.DefaultIfMTU
+	cfg.IfMTU = opts.IfMTU
+	if cfg.IfMTU == 0 {
+		cfg.IfMTU = DEFAULT_IF_MTU
+	}
+	cfg.LogLookups = opts.LogLookups
+	cfg.NodeInfo = opts.NodeInfo
 	cfg.NodeInfoPrivacy = true
 	if err := cfg.GenerateSelfSignedCertificate(); err != nil {
-		panic(err)
+		return err
 	}
 
 	n.config = cfg
+	n.filter = PeerFilter{
+		Allow: slices.Clone(opts.AllowedPublicKeys),
+		Deny:  slices.Clone(opts.DeniedPublicKeys),
+	}
+	n.state = NODE_STATE_CONFIGURED
+	return nil
 }
 
+// Get the node's configuration. It is empty until GenerateConfig succeeds.
 func (n *Node) Config() config.NodeConfig {
+	n.stateMu.RLock()
+	defer n.stateMu.RUnlock()
+
+	if n.config == nil {
+		return config.NodeConfig{}
+	}
 	return *n.config
 }
 
+// Start the node. Running a node that is already running does nothing. If
+// starting fails, the node is closed.
 func (n *Node) Run() error {
-	var err error = nil
-	// Have we got a working configuration? Stop if we don't.
-	if n.config == nil {
+	n.stateMu.Lock()
+	defer n.stateMu.Unlock()
+
+	switch n.state {
+	case NODE_STATE_NEW:
+		// Have we got a working configuration? Stop if we don't.
 		return fmt.Errorf("no configuration supplied")
+	case NODE_STATE_RUNNING:
+		return nil
+	case NODE_STATE_CLOSED:
+		return ErrNodeClosed
 	}
 
+	if err := n.start(); err != nil {
+		// Don't leave anything half started behind.
+		return errors.Join(err, n.shutdown())
+	}
+	n.state = NODE_STATE_RUNNING
+	return nil
+}
+
+func (n *Node) start() error {
+	var err error = nil
+	logger := NewYggdrasilLogger(n.logger)
+
 	// Setup the Yggdrasil node.
 	{
 		options := []core.SetupOption{
 			core.NodeInfo(n.config.NodeInfo),
 			core.NodeInfoPrivacy(n.config.NodeInfoPrivacy),
 		}
-		for _, addr := range n.config.Listen {
-			options = append(options, core.ListenAddress(addr))
-		}
+		n.peersMu.Lock()
 		for _, peer := range n.config.Peers {
 			options = append(options, core.Peer{URI: peer})
+			if u, err := url.Parse(peer); err == nil {
+				n.peers[u.String()] = u
+			}
 		}
+		n.peersMu.Unlock()
 		for intf, peers := range n.config.InterfacePeers {
 			for _, peer := range peers {
 				options = append(options, core.Peer{URI: peer, SourceInterface: intf})
 			}
 		}
-		for _, allowed := range n.config.AllowedPublicKeys {
-			k, err := hex.DecodeString(allowed)
+		n.admitted = n.filter.allowed()
+		for _, allowed := range n.admitted {
+			options = append(options, core.AllowedPublicKey(allowed))
+		}
+		if n.core, err = core.New(n.config.Certificate, logger, options...); err != nil {
+			return err
+		}
+		// Start the listeners ourselves to find out which of them work.
+		for _, addr := range n.config.Listen {
+			u, err := url.Parse(addr)
 			if err != nil {
-				return err
+				return fmt.Errorf("invalid listen address %q: %w", addr, err)
 			}
-			options = append(options, core.AllowedPublicKey(k[:]))
+			listener, err := n.core.Listen(u, "")
+			if err != nil {
+				n.logger.Error("failed to start listener", "uri", addr, "err", err)
+				continue
+			}
+			n.listeners = append(n.listeners, listener)
 		}
-		if n.core, err = core.New(n.config.Certificate, n.logger, options...); err != nil {
+		if err = n.setupAdminAPI(); err != nil {
 			return err
 		}
 	}
@@ -130,7 +597,7 @@ func (n *Node) Run() error {
 		if n.config.LogLookups {
 			options = append(options, admin.LogLookups{})
 		}
-		if n.admin, err = admin.New(n.core, n.logger, options...); err != nil {
+		if n.admin, err = admin.New(n.core, logger, options...); err != nil {
 			return err
 		}
 		if n.admin != nil {
@@ -138,12 +605,16 @@ func (n *Node) Run() error {
 		}
 	}
 
-	// Setup the multicast module.
-	{
+	// Setup the multicast module, unless it is disabled.
+	if len(n.config.MulticastInterfaces) != 0 {
 		options := []multicast.SetupOption{}
 		for _, intf := range n.config.MulticastInterfaces {
+			regex, err := regexp.Compile(intf.Regex)
+			if err != nil {
+				return fmt.Errorf("invalid multicast interface regex %q: %w", intf.Regex, err)
+			}
 			options = append(options, multicast.MulticastInterface{
-				Regex:    regexp.MustCompile(intf.Regex),
+				Regex:    regex,
 				Beacon:   intf.Beacon,
 				Listen:   intf.Listen,
 				Port:     intf.Port,
@@ -151,10 +622,13 @@ func (n *Node) Run() error {
 				Password: intf.Password,
 			})
 		}
-		if n.multicast, err = multicast.New(n.core, n.logger, options...); err != nil {
+		if n.multicast, err = multicast.New(n.core, logger.gologme(), options...); err != nil {
 			return err
 		}
-		if n.admin != nil && n.multicast != nil {
+		if err = n.setupMulticastAdminAPI(); err != nil {
+			return err
+		}
+		if n.admin != nil {
 			n.multicast.SetupAdminHandlers(n.admin)
 		}
 	}
@@ -0,0 +1,220 @@
+package radio
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+)
+
+func newTestOptions(t *testing.T) NodeOptions {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NodeOptions{PrivateKey: key}
+}
+
+// Create a node that is configured and running, and closed once the test is
+// over.
+func newRunningNode(t *testing.T, opts NodeOptions) *Node {
+	t.Helper()
+
+	n := NewNode(nil)
+	t.Cleanup(func() { n.Close() })
+	if err := n.GenerateConfig(opts); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Run(); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func assertState(t *testing.T, n *Node, want NodeState) {
+	t.Helper()
+
+	if got := n.State(); got != want {
+		t.Fatalf("node is %s, want %s", got, want)
+	}
+}
+
+func TestNodeLifecycle(t *testing.T) {
+	n := NewNode(nil)
+	assertState(t, n, NODE_STATE_NEW)
+
+	opts := newTestOptions(t)
+	if err := n.GenerateConfig(opts); err != nil {
+		t.Fatal(err)
+	}
+	assertState(t, n, NODE_STATE_CONFIGURED)
+	if got := n.Config().PrivateKey; !opts.PrivateKey.Equal(ed25519.PrivateKey(got)) {
+		t.Error("configuration does not hold the node's private key")
+	}
+
+	if err := n.Run(); err != nil {
+		t.Fatal(err)
+	}
+	assertState(t, n, NODE_STATE_RUNNING)
+	if err := n.GenerateConfig(opts); err == nil {
+		t.Error("a running node was reconfigured")
+	}
+	if addr, _ := n.Address(); addr == nil {
+		t.Error("running node has no address")
+	}
+	if stats := n.Stats(); stats.Self.Key == "" {
+		t.Error("running node reports no stats")
+	}
+
+	if err := n.Close(); err != nil {
+		t.Fatal(err)
+	}
+	assertState(t, n, NODE_STATE_CLOSED)
+	select {
+	case <-n.Done():
+	default:
+		t.Error("Done is not closed after Close")
+	}
+}
+
+func TestNodeRunTwice(t *testing.T) {
+	n := newRunningNode(t, newTestOptions(t))
+
+	if err := n.Run(); err != nil {
+		t.Errorf("running a running node failed: %v", err)
+	}
+	assertState(t, n, NODE_STATE_RUNNING)
+}
+
+func TestNodeCloseTwice(t *testing.T) {
+	n := newRunningNode(t, newTestOptions(t))
+
+	if err := n.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Close(); err != nil {
+		t.Errorf("closing a closed node failed: %v", err)
+	}
+	if err := n.Run(); !errors.Is(err, ErrNodeClosed) {
+		t.Errorf("running a closed node returned %v, want ErrNodeClosed", err)
+	}
+	if err := n.AddPeer("tcp://127.0.0.1:1"); !errors.Is(err, ErrNodeClosed) {
+		t.Errorf("adding a peer to a closed node returned %v, want ErrNodeClosed", err)
+	}
+	if _, err := n.AdminAPI().Call("getSelf", nil); !errors.Is(err, ErrNodeClosed) {
+		t.Errorf("admin command on a closed node returned %v, want ErrNodeClosed", err)
+	}
+}
+
+func TestNodeCloseUnstarted(t *testing.T) {
+	n := NewNode(nil)
+	if err := n.Close(); err != nil {
+		t.Fatal(err)
+	}
+	assertState(t, n, NODE_STATE_CLOSED)
+	if err := n.GenerateConfig(newTestOptions(t)); err == nil {
+		t.Error("a closed node was configured")
+	}
+}
+
+func TestNodeBeforeConfigure(t *testing.T) {
+	n := NewNode(nil)
+	defer n.Close()
+
+	if err := n.Run(); err == nil {
+		t.Error("a node without configuration was started")
+	}
+	assertState(t, n, NODE_STATE_NEW)
+
+	if cfg := n.Config(); cfg.PrivateKey != nil {
+		t.Error("unconfigured node has a configuration")
+	}
+	if addr, _ := n.Address(); addr != nil {
+		t.Errorf("unconfigured node has address %s", addr)
+	}
+	if peers := n.Peers(); len(peers) != 0 {
+		t.Errorf("unconfigured node has peers %v", peers)
+	}
+	if addrs := n.Listeners(); len(addrs) != 0 {
+		t.Errorf("unconfigured node has listeners %v", addrs)
+	}
+	if stats := n.Stats(); stats.Self.Key != "" {
+		t.Error("unconfigured node reports stats")
+	}
+	for name, err := range map[string]error{
+		"AddPeer":    n.AddPeer("tcp://127.0.0.1:1"),
+		"RemovePeer": n.RemovePeer("tcp://127.0.0.1:1"),
+		"SetPeers":   n.SetPeers(nil),
+	} {
+		if !errors.Is(err, ErrNodeNotRunning) {
+			t.Errorf("%s returned %v, want ErrNodeNotRunning", name, err)
+		}
+	}
+	if _, err := n.AdminAPI().Call("getSelf", nil); !errors.Is(err, ErrNodeNotRunning) {
+		t.Errorf("admin command returned %v, want ErrNodeNotRunning", err)
+	}
+}
+
+func TestNodePeers(t *testing.T) {
+	opts := newTestOptions(t)
+	opts.Peers = []string{"tcp://127.0.0.1:1"}
+	n := newRunningNode(t, opts)
+
+	if got := n.ConfiguredPeers(); !slices.Equal(got, []string{"tcp://127.0.0.1:1"}) {
+		t.Errorf("configured peers are %v", got)
+	}
+	if err := n.AddPeer("tcp://127.0.0.1:2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := n.AdminAPI().Call("removePeer", map[string]string{"uri": "tcp://127.0.0.1:1"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := n.ConfiguredPeers(); !slices.Equal(got, []string{"tcp://127.0.0.1:2"}) {
+		t.Errorf("configured peers are %v after adding and removing", got)
+	}
+	if err := n.SetPeers([]string{"tcp://127.0.0.1:3", "tcp://127.0.0.1:4"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := n.ConfiguredPeers(); !slices.Equal(got, []string{"tcp://127.0.0.1:3", "tcp://127.0.0.1:4"}) {
+		t.Errorf("configured peers are %v after setting them", got)
+	}
+	if err := n.AddPeer("not a uri"); err == nil {
+		t.Error("invalid peer URI was accepted")
+	}
+}
+
+func TestNodeCloseWhileInUse(t *testing.T) {
+	n := newRunningNode(t, newTestOptions(t))
+
+	// Run with -race to catch accesses to the node that Close does not wait
+	// for.
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				n.Peers()
+				n.Stats()
+				n.Listeners()
+				_ = n.AddPeer("tcp://127.0.0.1:1")
+				_, _ = n.AdminAPI().Call("getPeers", nil)
+			}
+		}()
+	}
+
+	if err := n.Close(); err != nil {
+		t.Error(err)
+	}
+	close(stop)
+	wg.Wait()
+}
@@ -0,0 +1,47 @@
+package radio
+
+import (
+	"encoding/hex"
+	"time"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/core"
+)
+
+// The state of a single Yggdrasil peering.
+type PeerStatus struct {
+	URI           string        `json:"uri"`
+	Up            bool          `json:"up"`
+	Inbound       bool          `json:"inbound,omitempty"`
+	Key           string        `json:"key,omitempty"`
+	Uptime        time.Duration `json:"uptime,omitempty"`
+	RXBytes       uint64        `json:"rx_bytes"`
+	TXBytes       uint64        `json:"tx_bytes"`
+	LastError     string        `json:"last_error,omitempty"`
+	LastErrorTime *time.Time    `json:"last_error_time,omitempty"`
+}
+
+// Convert peer information from Yggdrasil into a form fit for the wire.
+func NewPeerStatuses(peers []core.PeerInfo) []PeerStatus {
+	statuses := make([]PeerStatus, 0, len(peers))
+	for _, peer := range peers {
+		status := PeerStatus{
+			URI:     peer.URI,
+			Up:      peer.Up,
+			Inbound: peer.Inbound,
+			Uptime:  peer.Uptime,
+			RXBytes: peer.RXBytes,
+			TXBytes: peer.TXBytes,
+		}
+		if len(peer.Key) != 0 {
+			status.Key = hex.EncodeToString(peer.Key)
+		}
+		if peer.LastError != nil {
+			status.LastError = peer.LastError.Error()
+			// Copy the time, since peer is reused by every iteration.
+			errorTime := peer.LastErrorTime
+			status.LastErrorTime = &errorTime
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
@@ -0,0 +1,34 @@
+package radio
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/core"
+)
+
+func TestNewPeerStatusesErrorTimes(t *testing.T) {
+	first := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	second := first.Add(time.Hour)
+	statuses := NewPeerStatuses([]core.PeerInfo{
+		{URI: "tcp://127.0.0.1:1", LastError: errors.New("refused"), LastErrorTime: first},
+		{URI: "tcp://127.0.0.1:2", LastError: errors.New("timed out"), LastErrorTime: second},
+		{URI: "tcp://127.0.0.1:3", Up: true},
+	})
+
+	if len(statuses) != 3 {
+		t.Fatalf("got %d statuses, want 3", len(statuses))
+	}
+	for i, want := range []time.Time{first, second} {
+		if got := statuses[i].LastErrorTime; got == nil || !got.Equal(want) {
+			t.Errorf("peer %s has error time %v, want %v", statuses[i].URI, got, want)
+		}
+	}
+	if statuses[1].LastError != "timed out" {
+		t.Errorf("peer %s has error %q", statuses[1].URI, statuses[1].LastError)
+	}
+	if statuses[2].LastErrorTime != nil {
+		t.Errorf("peer %s has an error time but no error", statuses[2].URI)
+	}
+}
@@ -0,0 +1,108 @@
+package radio
+
+import (
+	"encoding/hex"
+	"time"
+)
+
+// A snapshot of the state of a Node.
+type NodeStats struct {
+	// When the snapshot was taken.
+	Time time.Time `json:"time"`
+
+	Self     SelfStats      `json:"self"`
+	Peers    []PeerStatus   `json:"peers"`
+	Sessions []SessionStats `json:"sessions"`
+
+	// The number of known paths to other nodes.
+	Paths int `json:"paths"`
+
+	Tree TreeStats `json:"tree"`
+}
+
+// Information about the node itself.
+type SelfStats struct {
+	Key            string `json:"key"`
+	Address        string `json:"address"`
+	Subnet         string `json:"subnet"`
+	RoutingEntries uint64 `json:"routing_entries"`
+}
+
+// Traffic exchanged with another node over the network, not necessarily a
+// direct peer.
+type SessionStats struct {
+	Key     string        `json:"key"`
+	Uptime  time.Duration `json:"uptime"`
+	RXBytes uint64        `json:"rx_bytes"`
+	TXBytes uint64        `json:"tx_bytes"`
+}
+
+// The node's view of the spanning tree.
+type TreeStats struct {
+	// The number of nodes in the tree known to this node.
+	Entries int `json:"entries"`
+
+	// The keys of this node's parent and of the tree root, as far as known.
+	Parent string `json:"parent,omitempty"`
+	Root   string `json:"root,omitempty"`
+}
+
+// Take a snapshot of the node's state. A node that isn't running has nothing
+// to report.
+func (n *Node) Stats() NodeStats {
+	n.stateMu.RLock()
+	defer n.stateMu.RUnlock()
+
+	if n.runningErr() != nil {
+		return NodeStats{Time: time.Now(), Peers: []PeerStatus{}, Sessions: []SessionStats{}}
+	}
+	self := n.core.GetSelf()
+	address, subnet := n.address()
+	stats := NodeStats{
+		Time: time.Now(),
+		Self: SelfStats{
+			Key:            hex.EncodeToString(self.Key),
+			Address:        address.String(),
+			Subnet:         subnet.String(),
+			RoutingEntries: self.RoutingEntries,
+		},
+		Peers:    NewPeerStatuses(n.core.GetPeers()),
+		Sessions: []SessionStats{},
+		Paths:    len(n.core.GetPaths()),
+	}
+
+	for _, session := range n.core.GetSessions() {
+		stats.Sessions = append(stats.Sessions, SessionStats{
+			Key:     hex.EncodeToString(session.Key),
+			Uptime:  session.Uptime,
+			RXBytes: session.RXBytes,
+			TXBytes: session.TXBytes,
+		})
+	}
+
+	// Walk up the tree from this node to find its parent and the root, which
+	// is its own parent.
+	tree := n.core.GetTree()
+	stats.Tree.Entries = len(tree)
+	parents := make(map[string]string, len(tree))
+	for _, entry := range tree {
+		parents[string(entry.Key)] = string(entry.Parent)
+	}
+	key := string(self.Key)
+	if parent, ok := parents[key]; ok {
+		stats.Tree.Parent = hex.EncodeToString([]byte(parent))
+	}
+	for steps := 0; steps <= len(tree); steps++ {
+		parent, ok := parents[key]
+		if !ok {
+			break
+		}
+		if parent == key {
+			stats.Tree.Root = hex.EncodeToString([]byte(key))
+			break
+		}
+		key = parent
+	}
+
+	return stats
+}
@@ -0,0 +1,253 @@
+package radio
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Peer history event types.
+const (
+	PEER_EVENT_UP      = "up"
+	PEER_EVENT_DOWN    = "down"
+	PEER_EVENT_ADDED   = "added"
+	PEER_EVENT_REMOVED = "removed"
+	// An inbound peer the node's PeerFilter does not permit is connected.
+	PEER_EVENT_DENIED = "denied"
+)
+
+// Settings for a PeerSupervisor.
+type PeerSupervisorOptions struct {
+	// Peers to fall back to, in order of preference, when none of the node's
+	// own peers are up.
+	Fallback []string
+
+	// The maximum number of outbound peers, the node's own included, to have
+	// at once. Fallback peers are only added while there is room. Defaults to
+	// one more than the node's own peers.
+	MaxPeers int
+
+	// How often to check on the peers. A fallback peer that is still down one
+	// interval after being added is swapped for the next one. Defaults to 30s.
+	Interval time.Duration
+
+	// How many history entries to keep. Defaults to 100.
+	HistorySize int
+
+	// If set, called with a copy of the history every time it changes.
+	OnHistory func([]PeerHistoryEntry)
+}
+
+// Something that happened to one of the node's peerings.
+type PeerHistoryEntry struct {
+	Time  time.Time `json:"time"`
+	URI   string    `json:"uri"`
+	Event string    `json:"event"`
+	Error string    `json:"error,omitempty"`
+}
+
+// Keeps a node connected by rotating through fallback peers while its own
+// peers are down, and keeps a history of peer connectivity. Inbound peers
+// which the node's PeerFilter does not permit are reported in the history and
+// the node's log, since Yggdrasil can't be made to drop them.
+type PeerSupervisor struct {
+	node *Node
+	opts PeerSupervisorOptions
+
+	mu      sync.Mutex
+	history []PeerHistoryEntry
+
+	// Peer state as of the last check, keyed by URI.
+	up map[string]bool
+	// Inbound peers already reported as denied, keyed by URI.
+	denied map[string]struct{}
+	// The fallback peers currently in use and when they were added.
+	active map[string]time.Time
+	// The index of the next fallback peer to try.
+	next int
+}
+
+func NewPeerSupervisor(node *Node, opts PeerSupervisorOptions) *PeerSupervisor {
+	if opts.Interval <= 0 {
+		opts.Interval = 30 * time.Second
+	}
+	if opts.HistorySize <= 0 {
+		opts.HistorySize = 100
+	}
+	opts.Fallback = slices.Clone(opts.Fallback)
+	for i, uri := range opts.Fallback {
+		// Normalise so the URIs match those reported by the node.
+		if u, err := url.Parse(uri); err == nil {
+			opts.Fallback[i] = u.String()
+		}
+	}
+
+	return &PeerSupervisor{
+		node:   node,
+		opts:   opts,
+		up:     map[string]bool{},
+		denied: map[string]struct{}{},
+		active: map[string]time.Time{},
+	}
+}
+
+// Supervise the node's peers until the context is cancelled or the node is
+// closed. Fallback peers still in use are removed before returning.
+func (s *PeerSupervisor) Run(ctx context.Context) {
+	defer func() {
+		select {
+		case <-s.node.Done():
+			// The peers went with the node.
+		default:
+			for uri := range s.active {
+				_ = s.node.RemovePeer(uri)
+			}
+		}
+	}()
+
+	// Check straight away, then on every tick. Checks are timed by the tick
+	// rather than by when they get to run, so a fallback peer is swapped on
+	// the first tick a full interval after it was added.
+	s.check(time.Now())
+	ticker := time.NewTicker(s.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.node.Done():
+			return
+		case now := <-ticker.C:
+			s.check(now)
+		}
+	}
+}
+
+// Get a copy of the peer history, oldest first.
+func (s *PeerSupervisor) History() []PeerHistoryEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return slices.Clone(s.history)
+}
+
+func (s *PeerSupervisor) check(now time.Time) {
+	entries := []PeerHistoryEntry{}
+
+	// Note peers going up or down.
+	ownUp := false
+	seen := map[string]struct{}{}
+	filter := s.node.PeerFilter()
+	for _, peer := range s.node.Peers() {
+		if peer.Inbound {
+			seen[peer.URI] = struct{}{}
+			if _, reported := s.denied[peer.URI]; !reported && !filter.Permits(peer.Key) {
+				key := hex.EncodeToString(peer.Key)
+				s.node.logger.Warn("inbound peer is not permitted by the peer filter", "uri", peer.URI, "key", key)
+				entries = append(entries, PeerHistoryEntry{
+					Time:  now,
+					URI:   peer.URI,
+					Event: PEER_EVENT_DENIED,
+					Error: fmt.Sprintf("public key %s is not permitted", key),
+				})
+				s.denied[peer.URI] = struct{}{}
+			}
+			continue
+		}
+		seen[peer.URI] = struct{}{}
+		if was, known := s.up[peer.URI]; peer.Up != was || !known {
+			entry := PeerHistoryEntry{Time: now, URI: peer.URI, Event: PEER_EVENT_DOWN}
+			if peer.Up {
+				entry.Event = PEER_EVENT_UP
+			} else if peer.LastError != nil {
+				entry.Error = peer.LastError.Error()
+			}
+			// Don't fill the history with peers that never came up.
+			if peer.Up || known {
+				entries = append(entries, entry)
+			}
+		}
+		s.up[peer.URI] = peer.Up
+		if _, isFallback := s.active[peer.URI]; peer.Up && !isFallback {
+			ownUp = true
+		}
+	}
+	for uri := range s.up {
+		if _, ok := seen[uri]; !ok {
+			delete(s.up, uri)
+		}
+	}
+	for uri := range s.denied {
+		if _, ok := seen[uri]; !ok {
+			delete(s.denied, uri)
+		}
+	}
+
+	if ownUp {
+		// The node's own peers are back, so go quiet again.
+		for uri := range s.active {
+			entries = append(entries, s.remove(now, uri))
+		}
+	} else if len(s.opts.Fallback) != 0 {
+		// Swap out fallback peers which had their chance and didn't come up.
+		for uri, added := range s.active {
+			if !s.up[uri] && now.Sub(added) >= s.opts.Interval {
+				entries = append(entries, s.remove(now, uri))
+			}
+		}
+		// Fill the free slots with the next fallback peers.
+		configured := s.node.ConfiguredPeers()
+		maxPeers := s.opts.MaxPeers
+		if maxPeers <= 0 {
+			maxPeers = len(configured) - len(s.active) + 1
+		}
+		for tries := 0; len(configured) < maxPeers && tries < len(s.opts.Fallback); tries++ {
+			uri := s.opts.Fallback[s.next]
+			s.next = (s.next + 1) % len(s.opts.Fallback)
+			if slices.Contains(configured, uri) {
+				// Already in use, as a fallback or one of the node's own peers.
+				continue
+			}
+			entry := PeerHistoryEntry{Time: now, URI: uri, Event: PEER_EVENT_ADDED}
+			if err := s.node.AddPeer(uri); err != nil {
+				entry.Error = err.Error()
+			} else {
+				s.active[uri] = now
+				configured = append(configured, uri)
+			}
+			entries = append(entries, entry)
+		}
+	}
+
+	if len(entries) != 0 {
+		s.record(entries...)
+	}
+}
+
+func (s *PeerSupervisor) remove(now time.Time, uri string) PeerHistoryEntry {
+	entry := PeerHistoryEntry{Time: now, URI: uri, Event: PEER_EVENT_REMOVED}
+	if err := s.node.RemovePeer(uri); err != nil {
+		entry.Error = err.Error()
+	}
+	delete(s.active, uri)
+	return entry
+}
+
+func (s *PeerSupervisor) record(entries ...PeerHistoryEntry) {
+	s.mu.Lock()
+	s.history = append(s.history, entries...)
+	if excess := len(s.history) - s.opts.HistorySize; excess > 0 {
+		s.history = slices.Delete(s.history, 0, excess)
+	}
+	history := slices.Clone(s.history)
+	s.mu.Unlock()
+
+	if s.opts.OnHistory != nil {
+		s.opts.OnHistory(history)
+	}
+}
